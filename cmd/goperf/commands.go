@@ -0,0 +1,323 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"goperf/internal/compare"
+	"goperf/internal/config"
+	"goperf/internal/engine"
+	"goperf/internal/repeat"
+	"goperf/internal/report"
+	"goperf/internal/runfile"
+	"goperf/internal/search"
+)
+
+// version is set at build time with -ldflags "-X main.version=...". When
+// empty, the module version from the build info is used.
+var version string
+
+func runCmd(args []string, stdout, stderr io.Writer) error {
+	cfg, err := config.ParseOutput(args, stderr)
+	if err != nil {
+		return err
+	}
+	format, err := report.Lookup(cfg.Format)
+	if err != nil {
+		return err
+	}
+	// Check the baseline before the test rather than after it.
+	if cfg.Baseline != "" {
+		if _, err := runfile.Load(cfg.Baseline); err != nil {
+			return fmt.Errorf("baseline: %w", err)
+		}
+	}
+	if cfg.Repeat > 1 {
+		return repeatRuns(cfg, stdout, stderr)
+	}
+
+	res, err := engine.Run(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Save before printing so the run survives a failing output.
+	if cfg.Save != "" {
+		if err := runfile.Save(cfg.Save, runfile.New(cfg, res)); err != nil {
+			return fmt.Errorf("saving run: %w", err)
+		}
+	}
+
+	if err := format(stdout, cfg, res); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return checkThresholds(cfg, res)
+}
+
+// checkThresholds returns an error listing the thresholds res fails.
+func checkThresholds(cfg config.Config, res engine.Result) error {
+	failed := report.Failed(report.Checks(cfg, res))
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(failed))
+	for i, c := range failed {
+		msgs[i] = c.Message()
+	}
+	return fmt.Errorf("%d of %d thresholds failed: %s", len(failed), len(cfg.Thresholds), strings.Join(msgs, "; "))
+}
+
+// repeatRuns runs the test cfg.Repeat times, reporting progress on stderr
+// and the variation between runs on stdout. With -save and -log-requests
+// each run writes its own files, numbered from 1.
+func repeatRuns(cfg config.Config, stdout, stderr io.Writer) error {
+	if cfg.Format != "text" {
+		return fmt.Errorf("-repeat supports only the text format, got %q", cfg.Format)
+	}
+
+	results, err := repeat.Run(cfg, numberedLogs(engine.Run), func(i int, res engine.Result) {
+		s := report.ComputeHeadline(res)
+		fmt.Fprintf(stderr, "run %d/%d: %.2f req/s, p50 %s, p99 %s, %d failed\n", i, cfg.Repeat,
+			s.RPS, s.P50.Round(time.Microsecond), s.P99.Round(time.Microsecond), res.Failed)
+	})
+	if err != nil {
+		return err
+	}
+
+	if cfg.Save != "" {
+		for i, res := range results {
+			if err := runfile.Save(numbered(cfg.Save, i+1), runfile.New(cfg, res)); err != nil {
+				return fmt.Errorf("saving run %d: %w", i+1, err)
+			}
+		}
+	}
+
+	if err := repeat.Print(stdout, cfg, repeat.Summarize(results)); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+
+	// A gate must hold in every run, not just on average.
+	var errs []string
+	for i, res := range results {
+		if err := checkThresholds(cfg, res); err != nil {
+			errs = append(errs, fmt.Sprintf("run %d: %v", i+1, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d runs failed thresholds: %s", len(errs), len(results), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// numbered inserts -n before the extension of path: run.goperf becomes
+// run-2.goperf.
+func numbered(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// numberedLogs wraps run so that each call writes any -log-requests file
+// under its own name, numbered from 1, rather than truncating the
+// previous call's log.
+func numberedLogs(run func(config.Config) (engine.Result, error)) func(config.Config) (engine.Result, error) {
+	var n int
+	return func(cfg config.Config) (engine.Result, error) {
+		n++
+		if cfg.LogRequests != "" {
+			cfg.LogRequests = numbered(cfg.LogRequests, n)
+		}
+		return run(cfg)
+	}
+}
+
+// reportCmd renders the report of a run saved with -save.
+func reportCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("goperf report", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	name := fs.String("format", "text", "Report format: "+strings.Join(report.Formats(), ", "))
+	baseline := fs.String("baseline", "", "Saved run the markdown format shows changes from")
+	noHeader := fs.Bool("csv-no-header", false, "With the csv format, omit the header row to append to an existing file")
+	timeline := fs.String("csv-timeline", "", "With the csv format, write the per-second timeline to this CSV file")
+	status := fs.String("csv-status", "", "With the csv format, write the count of each status code to this CSV file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: goperf report [flags] FILE\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	files, err := parseFiles(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	format, err := report.Lookup(*name)
+	if err != nil {
+		return err
+	}
+	if *baseline != "" && *name != "markdown" {
+		return fmt.Errorf("-baseline applies only to the markdown format, got %q", *name)
+	}
+	if (*noHeader || *timeline != "" || *status != "") && *name != "csv" {
+		return fmt.Errorf("-csv-no-header, -csv-timeline and -csv-status apply only to the csv format, got %q", *name)
+	}
+	run, err := runfile.Load(files[0])
+	if err != nil {
+		return err
+	}
+	if *baseline != "" {
+		run.Config.Baseline = *baseline
+	}
+	// The csv options are those given here, so files named when the run
+	// was saved are not rewritten unless asked.
+	run.Config.CSVNoHeader, run.Config.CSVTimeline, run.Config.CSVStatus = *noHeader, *timeline, *status
+	if err := format(stdout, run.Config, run.Result); err != nil {
+		return err
+	}
+	return checkThresholds(run.Config, run.Result)
+}
+
+// compareCmd compares two saved runs and fails when a metric regressed
+// significantly beyond its budget.
+func compareCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("goperf compare", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	budgetFlag := fs.String("budget", "5,errors=1", "Tolerated regression: percent for rps and latency, points for errors; e.g. 5,p99=10,errors=0.5")
+	opts := compare.Options{Seed: 1}
+	fs.Float64Var(&opts.Alpha, "alpha", 0.05, "Significance level")
+	fs.IntVar(&opts.Resamples, "resamples", 1000, "Bootstrap resamples for percentile confidence intervals")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: goperf compare [flags] BASE NEW\n\nCompares two runs saved with -save.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	files, err := parseFiles(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	budget, err := compare.ParseBudget(*budgetFlag, nil)
+	if err != nil {
+		return err
+	}
+	if opts.Alpha <= 0 || opts.Alpha >= 1 {
+		return fmt.Errorf("alpha must be between 0 and 1, got %g", opts.Alpha)
+	}
+	if opts.Resamples < 100 {
+		return fmt.Errorf("resamples must be at least 100, got %d", opts.Resamples)
+	}
+
+	base, err := runfile.Load(files[0])
+	if err != nil {
+		return err
+	}
+	next, err := runfile.Load(files[1])
+	if err != nil {
+		return err
+	}
+
+	rows := compare.Compare(base.Result, next.Result, budget, opts)
+	if err := compare.Print(stdout, files[0], files[1], rows); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	if reg := compare.Regressions(rows); len(reg) > 0 {
+		names := make([]string, len(reg))
+		for i, r := range reg {
+			names[i] = r.Metric
+		}
+		return fmt.Errorf("regression budget exceeded: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// findMaxCmd steps load up against the target until the SLO is missed,
+// reporting progress on stderr and the result on stdout. With
+// -log-requests each step writes its own log, numbered from 1.
+func findMaxCmd(args []string, stdout, stderr io.Writer) error {
+	cfg, s, err := config.ParseSearchOutput(args, stderr)
+	if err != nil {
+		return err
+	}
+
+	out, err := search.Run(cfg, s, numberedLogs(engine.Run), func(st search.Step) {
+		verdict := "pass"
+		if !st.Pass {
+			verdict = "FAIL"
+		}
+		if st.Dropped > 0 {
+			verdict += fmt.Sprintf(" (%d arrivals dropped; raise -concurrency)", st.Dropped)
+		}
+		fmt.Fprintf(stderr, "%s %g: p99 %s, errors %.2f%%, %.2f req/s: %s\n",
+			s.Mode, st.Load, st.P99.Round(time.Microsecond), st.ErrorRate, st.RPS, verdict)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := search.Print(stdout, s, out); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
+
+func versionCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("goperf version", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: goperf version\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("version takes no arguments, got %q", fs.Arg(0))
+	}
+
+	fmt.Fprintf(stdout, "goperf %s (%s %s/%s)\n", buildVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+// buildVersion returns the version set at link time, falling back to the
+// module version and VCS revision recorded by the go command.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := info.Main.Version
+	if v == "" || v == "(devel)" {
+		v = "devel"
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			v += " " + s.Value[:12]
+		}
+	}
+	return v
+}
+
+// parseFiles parses a command's flags and its n file arguments, which may
+// be mixed with the flags.
+func parseFiles(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var files []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		files = append(files, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(files) != n {
+		fs.Usage()
+		return nil, fmt.Errorf("want %d file argument(s), got %d", n, len(files))
+	}
+	return files, nil
+}
@@ -1,25 +1,99 @@
+// Command goperf is an HTTP load testing tool.
+//
+// Usage:
+//
+//	goperf <command> [flags]
+//
+// Running goperf with flags but no command is the same as goperf run.
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-
-	"goperf/internal/config"
-	"goperf/internal/engine"
-	"goperf/internal/report"
+	"strings"
 )
 
+// command is a goperf subcommand. run parses its own flags from args and
+// returns flag.ErrHelp when help was requested.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{"run", "Run a load test and print the report", runCmd},
+	{"report", "Print the report of a run saved with -save", reportCmd},
+	{"compare", "Compare two saved runs and flag significant regressions", compareCmd},
+	{"find-max", "Find the highest load that meets a latency and error SLO", findMaxCmd},
+	{"version", "Print the goperf version", versionCmd},
+}
+
 func main() {
-	cfg, err := config.Parse(os.Args[1:])
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	os.Exit(dispatch(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// dispatch runs the command named by args[0], or run when args starts
+// with a flag, and returns the exit code.
+func dispatch(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	name := "run"
+	switch args[0] {
+	case "-h", "-help", "--help":
+		name, args = "help", args[1:]
+	default:
+		if !strings.HasPrefix(args[0], "-") {
+			name, args = args[0], args[1:]
+		}
 	}
 
-	res := engine.Run(cfg)
+	if name == "help" {
+		if len(args) == 0 {
+			usage(stdout)
+			return 0
+		}
+		// Each command prints its own usage for -h.
+		name, args = args[0], []string{"-h"}
+	}
+
+	cmd := lookup(name)
+	if cmd == nil {
+		fmt.Fprintf(stderr, "goperf: unknown command %q\n\n", name)
+		usage(stderr)
+		return 2
+	}
+
+	err := cmd.run(args, stdout, stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case err != nil:
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func lookup(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
 
-	if err := report.Print(os.Stdout, cfg, res); err != nil {
-		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
-		os.Exit(1)
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: goperf <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s  %s\n", c.name, c.summary)
 	}
+	fmt.Fprintf(w, "\nRun \"goperf help <command>\" for a command's flags. Flags without a\ncommand, as in \"goperf -url http://...\", run a load test.\n")
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDispatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"no arguments", nil, 2, "", "Usage: goperf <command>"},
+		{"help", []string{"help"}, 0, "find-max", ""},
+		{"help flag", []string{"-h"}, 0, "Commands:", ""},
+		{"command help", []string{"help", "run"}, 0, "", "Usage: goperf run [flags]"},
+		{"command -h", []string{"find-max", "-h"}, 0, "", "-slo-p99"},
+		{"run -h", []string{"run", "-h"}, 0, "", "-concurrency"},
+		{"run bad flag", []string{"run", "-bogus"}, 1, "", "flag provided but not defined: -bogus"},
+		{"version help", []string{"help", "version"}, 0, "", "Usage: goperf version"},
+		{"unknown command", []string{"bench"}, 2, "", `unknown command "bench"`},
+		{"version", []string{"version"}, 0, "goperf ", ""},
+		{"version with arguments", []string{"version", "extra"}, 1, "", "takes no arguments"},
+		{"run", []string{"run", "-url", srv.URL, "-duration", "50ms", "-concurrency", "1"}, 0, "--- goperf results ---", ""},
+		{"bare flags run", []string{"-url", srv.URL, "-duration", "50ms", "-concurrency", "1"}, 0, "--- goperf results ---", ""},
+		{"json format", []string{"-url", srv.URL, "-duration", "50ms", "-concurrency", "1", "-format", "json"}, 0, `"requests":`, ""},
+		{"csv format", []string{"-url", srv.URL, "-duration", "50ms", "-concurrency", "1", "-format", "csv"}, 0, "started,method,url,", ""},
+		{"unknown format", []string{"-url", srv.URL, "-format", "yaml"}, 1, "", `unknown format "yaml"`},
+		{"report without file", []string{"report"}, 1, "", "want 1 file argument(s), got 0"},
+		{"report missing file", []string{"report", "nonexistent.goperf"}, 1, "", "no such file"},
+		{"report baseline without markdown", []string{"report", "-baseline", "base.goperf", "run.goperf"}, 1, "", "-baseline applies only to the markdown format"},
+		{"report csv file without csv", []string{"report", "-csv-status", "status.csv", "run.goperf"}, 1, "", "apply only to the csv format"},
+		{"compare one file", []string{"compare", "base.goperf"}, 1, "", "want 2 file argument(s), got 1"},
+		{"compare bad budget", []string{"compare", "-budget", "p95=1", "a", "b"}, 1, "", `unknown budget metric "p95"`},
+		{"run error", []string{"run", "-url", srv.URL, "-concurrency", "0"}, 1, "", "error: concurrency must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := dispatch(tt.args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d\nstderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("stdout missing %q:\n%s", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr missing %q:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestSaveAndReport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "run.goperf")
+
+	var stdout, stderr bytes.Buffer
+	if code := dispatch([]string{"run", "-url", srv.URL, "-duration", "50ms", "-concurrency", "1", "-save", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("run exited %d: %s", code, stderr.String())
+	}
+	live := stdout.String()
+
+	for _, args := range [][]string{{"report", path}, {"report", "-format", "text", path}, {"report", path, "-format", "text"}} {
+		stdout.Reset()
+		if code := dispatch(args, &stdout, &stderr); code != 0 {
+			t.Fatalf("%v exited %d: %s", args, code, stderr.String())
+		}
+		if stdout.String() != live {
+			t.Errorf("%v rendered\n%s\nwant the live report\n%s", args, stdout.String(), live)
+		}
+	}
+
+	stdout.Reset()
+	if code := dispatch([]string{"report", "-format", "json", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("report -format json exited %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `"url": "`+srv.URL+`"`) {
+		t.Errorf("json report missing the URL:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	status := filepath.Join(t.TempDir(), "status.csv")
+	if code := dispatch([]string{"report", "-format", "csv", "-csv-no-header", "-csv-status", status, path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("report -format csv exited %d: %s", code, stderr.String())
+	}
+	if row := stdout.String(); strings.Count(row, "\n") != 1 || !strings.Contains(row, ",GET,"+srv.URL+",1,") {
+		t.Errorf("csv report is not a single row for the run:\n%s", row)
+	}
+	if b, err := os.ReadFile(status); err != nil || !strings.HasPrefix(string(b), "status,count\n200,") {
+		t.Errorf("status file = %q, %v; want the count of 200 responses", b, err)
+	}
+}
+
+func TestCompareFlagsRegression(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	dir := t.TempDir()
+	base, next := filepath.Join(dir, "base.goperf"), filepath.Join(dir, "new.json")
+	var stdout, stderr bytes.Buffer
+	for _, run := range []struct{ url, path string }{{fast.URL, base}, {slow.URL, next}} {
+		if code := dispatch([]string{"run", "-url", run.url, "-duration", "200ms", "-concurrency", "2", "-save", run.path}, &stdout, &stderr); code != 0 {
+			t.Fatalf("run exited %d: %s", code, stderr.String())
+		}
+	}
+
+	stdout.Reset()
+	code := dispatch([]string{"compare", base, next}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("compare exited %d, want 1 for a regression\n%s", code, stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "regression budget exceeded") || !strings.Contains(stdout.String(), "REGRESSION") {
+		t.Errorf("regression not reported\nstdout:\n%s\nstderr:\n%s", stdout.String(), stderr.String())
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "run.goperf")
+
+	var stdout, stderr bytes.Buffer
+	log := filepath.Join(filepath.Dir(path), "requests.ndjson")
+	args := []string{"run", "-url", srv.URL, "-duration", "50ms", "-concurrency", "1", "-repeat", "3", "-save", path, "-log-requests", log}
+	if code := dispatch(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("run exited %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "--- goperf results: 3 runs ---") {
+		t.Errorf("missing repeat summary:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "run 3/3:") {
+		t.Errorf("missing progress:\n%s", stderr.String())
+	}
+	for _, name := range []string{"run-1.goperf", "run-2.goperf", "run-3.goperf", "requests-1.ndjson", "requests-2.ndjson", "requests-3.ndjson"} {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(path), name)); err != nil {
+			t.Errorf("run not saved: %v", err)
+		}
+	}
+
+	stderr.Reset()
+	if code := dispatch([]string{"run", "-url", srv.URL, "-repeat", "2", "-format", "json"}, &stdout, &stderr); code != 1 {
+		t.Errorf("repeat with json exited %d, want 1", code)
+	}
+}
+
+func TestFindMaxLogsEachStep(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	log := filepath.Join(t.TempDir(), "requests.ndjson")
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"find-max", "-url", srv.URL, "-duration", "50ms", "-start", "1", "-max", "2", "-log-requests", log}
+	if code := dispatch(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("find-max exited %d: %s", code, stderr.String())
+	}
+	for _, name := range []string{"requests-1.ndjson", "requests-2.ndjson"} {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(log), name))
+		if err != nil || len(data) == 0 {
+			t.Errorf("step log %s: %d bytes, err %v", name, len(data), err)
+		}
+	}
+}
+
+func TestRunFailsThresholds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"run", "-url", srv.URL, "-duration", "50ms", "-concurrency", "1", "-threshold", "p99<1m", "-format", "junit"}
+	if code := dispatch(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("run exited %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `<testcase name="p99 &lt; 1m0s"`) {
+		t.Errorf("junit report missing the threshold:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	path := filepath.Join(t.TempDir(), "run.goperf")
+	args = []string{"run", "-url", srv.URL, "-duration", "50ms", "-concurrency", "1", "-threshold", "requests>1000000000", "-save", path}
+	if code := dispatch(args, &stdout, &stderr); code != 1 {
+		t.Fatalf("run exited %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "1 of 1 thresholds failed: requests was") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Thresholds:   1 of 1 failed") {
+		t.Errorf("report missing the thresholds:\n%s", stdout.String())
+	}
+
+	// The saved run fails the same thresholds when reported again.
+	stderr.Reset()
+	if code := dispatch([]string{"report", path}, &stdout, &stderr); code != 1 {
+		t.Fatalf("report exited %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "1 of 1 thresholds failed") {
+		t.Errorf("report stderr = %q", stderr.String())
+	}
+
+	// With -repeat, every run must pass.
+	stderr.Reset()
+	args = []string{"run", "-url", srv.URL, "-duration", "50ms", "-concurrency", "1", "-repeat", "2", "-threshold", "requests>1000000000"}
+	if code := dispatch(args, &stdout, &stderr); code != 1 {
+		t.Fatalf("repeated run exited %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "2 of 2 runs failed thresholds: run 1: 1 of 1 thresholds failed") {
+		t.Errorf("repeated run stderr = %q", stderr.String())
+	}
+}
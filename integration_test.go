@@ -3,6 +3,7 @@ package goperf_test
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -24,7 +25,10 @@ func runFullPipeline(t *testing.T, args []string) (engine.Result, string) {
 		t.Fatalf("config parse: %v", err)
 	}
 
-	res := engine.Run(cfg)
+	res, err := engine.Run(cfg)
+	if err != nil {
+		t.Fatalf("engine run: %v", err)
+	}
 
 	var buf bytes.Buffer
 	if err := report.Print(&buf, cfg, res); err != nil {
@@ -304,3 +308,33 @@ func TestIntegration_ConnectionErrors(t *testing.T) {
 		t.Error("report output missing Errors section")
 	}
 }
+
+func TestIntegration_RequestTemplates(t *testing.T) {
+	var mismatched, received atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		seq := strings.TrimPrefix(r.URL.Path, "/orders/")
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Seq") != seq || string(body) != "seq="+seq {
+			mismatched.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	res, _ := runFullPipeline(t, []string{
+		"-url", srv.URL + "/orders/{{seq}}",
+		"-method", "POST",
+		"-header", "X-Seq: {{seq}}",
+		"-body", "seq={{seq}}",
+		"-concurrency", "3",
+		"-duration", "100ms",
+	})
+
+	if res.Succeeded == 0 || received.Load() == 0 {
+		t.Fatal("expected templated requests to succeed")
+	}
+	if n := mismatched.Load(); n > 0 {
+		t.Errorf("%d requests expanded {{seq}} inconsistently across URL, header and body", n)
+	}
+}
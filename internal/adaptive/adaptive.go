@@ -0,0 +1,79 @@
+// Package adaptive varies the number of active workers to hold the P95
+// latency at a target.
+//
+// The controller works like TCP congestion control (AIMD). It starts with
+// one worker and doubles them each interval until the target is first
+// exceeded (slow start). From then on it adds one worker per interval
+// while P95 is within the target and cuts a quarter of them when it is
+// not.
+package adaptive
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// decrease is the fraction of workers kept after an interval over target.
+const decrease = 0.75
+
+// Sample records one control interval.
+type Sample struct {
+	// Elapsed is the time from the start of the run to the end of the
+	// interval.
+	Elapsed time.Duration
+	// Workers is the number of workers active during the interval.
+	Workers  int
+	P95      time.Duration
+	Requests int
+}
+
+// Controller decides how many workers are active.
+type Controller struct {
+	Target time.Duration
+	Max    int
+
+	workers   int
+	slowStart bool
+}
+
+// New returns a controller targeting a P95 latency with at most max
+// workers, starting with one.
+func New(target time.Duration, max int) *Controller {
+	return &Controller{Target: target, Max: max, workers: 1, slowStart: true}
+}
+
+// Workers returns the number of workers that should be active.
+func (c *Controller) Workers() int {
+	return c.workers
+}
+
+// Observe takes the latencies of the requests completed during an
+// interval, adjusts the number of workers and returns the interval's P95.
+// An interval in which no request completed counts as over target, since
+// every active worker was stuck for the whole of it.
+func (c *Controller) Observe(latencies []time.Duration) time.Duration {
+	p95 := P95(latencies)
+	switch {
+	case len(latencies) == 0 || p95 > c.Target:
+		c.slowStart = false
+		c.workers = max(1, min(c.workers-1, int(float64(c.workers)*decrease)))
+	case c.slowStart:
+		c.workers = min(c.Max, c.workers*2)
+	default:
+		c.workers = min(c.Max, c.workers+1)
+	}
+	return p95
+}
+
+// P95 returns the 95th percentile of latencies by the nearest-rank
+// method, or zero if there are none.
+func P95(latencies []time.Duration) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	idx := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	return sorted[max(0, idx)]
+}
@@ -0,0 +1,66 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestControllerAIMD(t *testing.T) {
+	const fast, slow = 10 * time.Millisecond, time.Second
+	c := New(100*time.Millisecond, 20)
+
+	steps := []struct {
+		latency time.Duration
+		want    int
+	}{
+		{fast, 2}, // slow start doubles
+		{fast, 4},
+		{fast, 8},
+		{fast, 16},
+		{slow, 12}, // multiplicative decrease ends slow start
+		{fast, 13}, // additive increase
+		{fast, 14},
+		{slow, 10},
+		{0, 7}, // nothing completed
+		{fast, 8},
+	}
+	for i, s := range steps {
+		var lats []time.Duration
+		if s.latency > 0 {
+			lats = []time.Duration{s.latency, s.latency}
+		}
+		c.Observe(lats)
+		if got := c.Workers(); got != s.want {
+			t.Fatalf("step %d: workers = %d, want %d", i+1, got, s.want)
+		}
+	}
+}
+
+func TestControllerBounds(t *testing.T) {
+	c := New(100*time.Millisecond, 3)
+	for range 5 {
+		c.Observe([]time.Duration{time.Millisecond})
+	}
+	if got := c.Workers(); got != 3 {
+		t.Errorf("workers = %d, want capped at 3", got)
+	}
+	for range 5 {
+		c.Observe([]time.Duration{time.Second})
+	}
+	if got := c.Workers(); got != 1 {
+		t.Errorf("workers = %d, want at least 1", got)
+	}
+}
+
+func TestP95(t *testing.T) {
+	lats := make([]time.Duration, 100)
+	for i := range lats {
+		lats[i] = time.Duration(100-i) * time.Millisecond
+	}
+	if got := P95(lats); got != 95*time.Millisecond {
+		t.Errorf("P95 = %s, want 95ms", got)
+	}
+	if got := P95(nil); got != 0 {
+		t.Errorf("P95(nil) = %s, want 0", got)
+	}
+}
@@ -0,0 +1,118 @@
+// Package arrival schedules request arrivals for rate-based load.
+//
+// Processes are written as:
+//
+//	constant     evenly spaced arrivals
+//	poisson      exponentially distributed gaps with the same mean rate
+//	burst:N      N simultaneous arrivals every N/rate seconds
+package arrival
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Process generates the gaps between arrivals.
+type Process interface {
+	// Next returns the gap until the next arrival group and the number of
+	// arrivals in it.
+	Next(r *rand.Rand) (gap time.Duration, n int)
+	// String describes the process in the syntax accepted by Parse.
+	String() string
+}
+
+// Parse parses an arrival process for the given mean rate in arrivals per
+// second.
+func Parse(spec string, rate float64) (Process, error) {
+	if !(rate > 0) {
+		return nil, fmt.Errorf("arrival rate must be positive, got %g", rate)
+	}
+	// A gap that rounds to zero or overflows a Duration would spin the
+	// scheduler in a tight loop.
+	gap := float64(time.Second) / rate
+	if !(gap >= 1 && gap < math.MaxInt64) {
+		return nil, fmt.Errorf("arrival rate %g/s is out of range, want a gap between arrivals of 1ns to %s", rate, time.Duration(math.MaxInt64))
+	}
+	mean := time.Duration(gap)
+
+	kind, arg, hasArg := strings.Cut(spec, ":")
+	switch {
+	case kind == "constant" && !hasArg:
+		return Constant{Gap: mean}, nil
+	case kind == "poisson" && !hasArg:
+		return Poisson{Mean: mean}, nil
+	case kind == "burst" && hasArg:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("burst size must be a positive integer, got %q", arg)
+		}
+		if float64(n)*gap >= math.MaxInt64 {
+			return nil, fmt.Errorf("bursts of %d at %g/s are more than %s apart", n, rate, time.Duration(math.MaxInt64))
+		}
+		return Burst{Size: n, Every: time.Duration(n) * mean}, nil
+	default:
+		return nil, fmt.Errorf("unknown arrival process %q, want constant, poisson or burst:N", spec)
+	}
+}
+
+// Constant spaces arrivals evenly.
+type Constant struct {
+	Gap time.Duration
+}
+
+func (c Constant) Next(*rand.Rand) (time.Duration, int) { return c.Gap, 1 }
+func (c Constant) String() string                       { return "constant" }
+
+// Poisson spaces arrivals by exponentially distributed gaps.
+type Poisson struct {
+	Mean time.Duration
+}
+
+func (p Poisson) Next(r *rand.Rand) (time.Duration, int) {
+	return time.Duration(r.ExpFloat64() * float64(p.Mean)), 1
+}
+
+func (p Poisson) String() string { return "poisson" }
+
+// Burst releases Size arrivals at once every interval.
+type Burst struct {
+	Size  int
+	Every time.Duration
+}
+
+func (b Burst) Next(*rand.Rand) (time.Duration, int) { return b.Every, b.Size }
+func (b Burst) String() string                       { return "burst:" + strconv.Itoa(b.Size) }
+
+// Run sends one token on tickets per arrival until ctx is done. Arrivals
+// are scheduled against absolute times so timer latency does not
+// accumulate. When tickets is full every worker is busy, and the arrival
+// is dropped rather than delayed; Run returns the number dropped.
+func Run(ctx context.Context, p Process, r *rand.Rand, tickets chan<- struct{}) (dropped int) {
+	next := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		gap, n := p.Next(r)
+		next = next.Add(gap)
+		timer.Reset(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return dropped
+		}
+
+		for range n {
+			select {
+			case tickets <- struct{}{}:
+			default:
+				dropped++
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package arrival
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		rate float64
+		want Process
+	}{
+		{spec: "constant", rate: 100, want: Constant{Gap: 10 * time.Millisecond}},
+		{spec: "poisson", rate: 4, want: Poisson{Mean: 250 * time.Millisecond}},
+		{spec: "burst:5", rate: 50, want: Burst{Size: 5, Every: 100 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.spec, tt.rate)
+		if err != nil {
+			t.Errorf("Parse(%q, %g): %v", tt.spec, tt.rate, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q, %g) = %#v, want %#v", tt.spec, tt.rate, got, tt.want)
+		}
+		if got.String() != tt.spec {
+			t.Errorf("String() = %q, want %q", got.String(), tt.spec)
+		}
+	}
+
+	for _, spec := range []string{"", "uniform", "poisson:3", "burst", "burst:0", "burst:x"} {
+		if _, err := Parse(spec, 10); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", spec)
+		}
+	}
+	// Each rate gives a gap that is zero, overflows or is undefined.
+	for _, rate := range []float64{0, -1, math.NaN(), math.Inf(1), 2e9, 1e-12} {
+		if _, err := Parse("constant", rate); err == nil {
+			t.Errorf("Parse with rate %g succeeded, want error", rate)
+		}
+	}
+	if _, err := Parse("burst:1000", 1e-8); err == nil {
+		t.Error("Parse of bursts beyond the longest Duration succeeded, want error")
+	}
+}
+
+func TestPoissonIsSeedable(t *testing.T) {
+	p := Poisson{Mean: time.Second}
+	a, b := rand.New(rand.NewPCG(42, 0)), rand.New(rand.NewPCG(42, 0))
+	var sum time.Duration
+	const n = 10000
+	for range n {
+		ga, _ := p.Next(a)
+		gb, _ := p.Next(b)
+		if ga != gb {
+			t.Fatalf("same seed produced gaps %s and %s", ga, gb)
+		}
+		sum += ga
+	}
+	if mean := sum / n; mean < 950*time.Millisecond || mean > 1050*time.Millisecond {
+		t.Errorf("mean gap = %s, want about 1s", mean)
+	}
+}
+
+// runFor runs p for d and returns the tickets received and arrivals dropped.
+func runFor(p Process, d time.Duration, capacity int) (received, dropped int) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	tickets := make(chan struct{}, capacity)
+	dropped = Run(ctx, p, rand.New(rand.NewPCG(1, 1)), tickets)
+	return len(tickets), dropped
+}
+
+func TestRunConstantRate(t *testing.T) {
+	p, _ := Parse("constant", 200)
+	got, dropped := runFor(p, 100*time.Millisecond, 1000)
+	if got < 15 || got > 21 || dropped != 0 {
+		t.Errorf("received %d tickets (%d dropped) in 100ms at 200/s, want about 20", got, dropped)
+	}
+}
+
+func TestRunBurst(t *testing.T) {
+	p, _ := Parse("burst:10", 200) // 10 arrivals every 50ms
+	got, _ := runFor(p, 120*time.Millisecond, 1000)
+	if got != 20 {
+		t.Errorf("received %d tickets in 120ms, want two bursts of 10", got)
+	}
+}
+
+func TestRunDropsWhenFull(t *testing.T) {
+	p, _ := Parse("burst:10", 200)
+	got, dropped := runFor(p, 70*time.Millisecond, 4)
+	if got != 4 || dropped != 6 {
+		t.Errorf("received %d, dropped %d; want 4 queued and 6 dropped", got, dropped)
+	}
+}
@@ -0,0 +1,29 @@
+package compare
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func randomLatencies(n int, r *rand.Rand) []time.Duration {
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		latencies[i] = time.Duration(r.IntN(500_000)+1) * time.Microsecond
+	}
+	slices.Sort(latencies)
+	return latencies
+}
+
+func benchmarkBootstrapDiff(b *testing.B, n int) {
+	r := rand.New(rand.NewPCG(1, 0))
+	base, next := randomLatencies(n, r), randomLatencies(n, r)
+	b.ResetTimer()
+	for b.Loop() {
+		bootstrapDiff(base, next, 99, 0.95, 1000, r)
+	}
+}
+
+func BenchmarkBootstrapDiff10k(b *testing.B) { benchmarkBootstrapDiff(b, 10_000) }
+func BenchmarkBootstrapDiff1M(b *testing.B)  { benchmarkBootstrapDiff(b, 1_000_000) }
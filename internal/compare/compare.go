@@ -0,0 +1,283 @@
+// Package compare compares two load test runs metric by metric and tests
+// whether each difference is statistically significant or just noise.
+//
+// Latency percentiles are compared with bootstrap confidence intervals on
+// their difference, average latency and throughput with the Mann-Whitney
+// U test (throughput over the runs' one-second timelines), and error
+// rates with a two-proportion z-test.
+package compare
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"goperf/internal/engine"
+	"goperf/internal/report"
+)
+
+// Metrics lists the names of the compared metrics in report order.
+var Metrics = []string{"rps", "average", "p50", "p90", "p99", "errors"}
+
+// Budget is the largest tolerated regression per metric: a percentage
+// change for throughput and latency, and percentage points for the error
+// rate.
+type Budget map[string]float64
+
+// ParseBudget parses a budget such as "5,p99=10,errors=0.5": a bare
+// number sets every metric and name=value pairs override single metrics.
+// Metrics not mentioned keep the defaults in def.
+func ParseBudget(s string, def Budget) (Budget, error) {
+	b := make(Budget, len(Metrics))
+	for k, v := range def {
+		b[k] = v
+	}
+	for _, part := range strings.Split(s, ",") {
+		name, value, named := strings.Cut(strings.TrimSpace(part), "=")
+		if !named {
+			name, value = "", name
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid budget %q", part)
+		}
+		switch {
+		case !named:
+			for _, m := range Metrics {
+				b[m] = v
+			}
+		case slices.Contains(Metrics, name):
+			b[name] = v
+		default:
+			return nil, fmt.Errorf("unknown budget metric %q, want one of %s", name, strings.Join(Metrics, ", "))
+		}
+	}
+	return b, nil
+}
+
+// Row is the comparison of one metric. Latencies are in nanoseconds and
+// the error rate in percent.
+type Row struct {
+	Metric    string
+	Base, New float64
+	// Change is the percentage change, or for the error rate the
+	// difference in percentage points.
+	Change float64
+	// Significant reports whether the difference is unlikely to be noise,
+	// as described by Evidence.
+	Significant bool
+	Evidence    string
+	// Regressed reports a significant change for the worse beyond the
+	// budget.
+	Regressed bool
+	Budget    float64
+}
+
+// Options controls the significance tests.
+type Options struct {
+	// Alpha is the significance level, such as 0.05.
+	Alpha float64
+	// Resamples is the number of bootstrap resamples for percentiles.
+	Resamples int
+	// Seed seeds the bootstrap so comparisons are reproducible.
+	Seed uint64
+}
+
+// Compare compares the new run against the base run. Its latency rows
+// cover every request, failures included, since the significance tests
+// need the full samples; a run with failures therefore shows different
+// percentiles here than in its report, which headlines successes alone.
+func Compare(base, next engine.Result, budget Budget, opts Options) []Row {
+	bs, ns := report.Compute(base), report.Compute(next)
+	r := rand.New(rand.NewPCG(opts.Seed, 0))
+	sortedBase := sortedLatencies(base.Latencies)
+	sortedNext := sortedLatencies(next.Latencies)
+
+	rows := make([]Row, 0, len(Metrics))
+	add := func(name string, b, n float64, significant bool, evidence string) {
+		row := Row{Metric: name, Base: b, New: n, Significant: significant, Evidence: evidence, Budget: budget[name]}
+		worse := n > b
+		if name == "rps" {
+			worse = n < b
+		}
+		if name == "errors" {
+			row.Change = n - b
+		} else if b != 0 {
+			row.Change = 100 * (n - b) / b
+		}
+		row.Regressed = significant && worse && math.Abs(row.Change) > row.Budget
+		rows = append(rows, row)
+	}
+
+	// Throughput: compare the per-second request counts of the runs'
+	// full timeline intervals.
+	ra, rb := intervalCounts(base.Timeline), intervalCounts(next.Timeline)
+	if len(ra) < 2 || len(rb) < 2 {
+		add("rps", bs.RPS, ns.RPS, false, "n/a (runs too short)")
+	} else {
+		p := mannWhitney(ra, rb)
+		add("rps", bs.RPS, ns.RPS, p < opts.Alpha, formatP(p))
+	}
+
+	if len(base.Latencies) == 0 || len(next.Latencies) == 0 {
+		for _, m := range []string{"average", "p50", "p90", "p99"} {
+			add(m, 0, 0, false, "n/a (no requests)")
+		}
+	} else {
+		p := mannWhitney(floats(base.Latencies), floats(next.Latencies))
+		add("average", float64(bs.Average), float64(ns.Average), p < opts.Alpha, formatP(p))
+
+		for _, pc := range []struct {
+			name      string
+			p         float64
+			base, new time.Duration
+		}{
+			{"p50", 50, bs.P50, ns.P50},
+			{"p90", 90, bs.P90, ns.P90},
+			{"p99", 99, bs.P99, ns.P99},
+		} {
+			lo, hi := bootstrapDiff(sortedBase, sortedNext, pc.p, 1-opts.Alpha, opts.Resamples, r)
+			evidence := fmt.Sprintf("%g%% CI %s..%s", 100*(1-opts.Alpha), signed(lo), signed(hi))
+			add(pc.name, float64(pc.base), float64(pc.new), lo > 0 || hi < 0, evidence)
+		}
+	}
+
+	p := twoProportions(base.Failed, base.TotalRequests, next.Failed, next.TotalRequests)
+	add("errors", errorRate(base), errorRate(next), p < opts.Alpha, formatP(p))
+	return rows
+}
+
+// Regressions returns the rows that exceed their budget.
+func Regressions(rows []Row) []Row {
+	var out []Row
+	for _, r := range rows {
+		if r.Regressed {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Print writes the comparison as a table.
+func Print(w io.Writer, baseName, newName string, rows []Row) error {
+	_, err := fmt.Fprintf(w, `
+--- goperf compare ---
+Base:         %s
+New:          %s
+
+%-10s %12s %12s %12s %9s  %s
+`, baseName, newName, "Metric", "Base", "New", "Delta", "Change", "Significance")
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		change := fmt.Sprintf("%+.1f%%", r.Change)
+		if r.Metric == "errors" {
+			change = fmt.Sprintf("%+.2fpp", r.Change)
+		}
+		verdict := "noise"
+		if r.Significant {
+			verdict = "significant"
+		}
+		line := fmt.Sprintf("%-10s %12s %12s %12s %9s  %s, %s",
+			r.Metric, value(r.Metric, r.Base), value(r.Metric, r.New), delta(r.Metric, r.New-r.Base),
+			change, verdict, r.Evidence)
+		if r.Regressed {
+			line += fmt.Sprintf("  REGRESSION (budget %g%s)", r.Budget, budgetUnit(r.Metric))
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w)
+
+	if reg := Regressions(rows); len(reg) > 0 {
+		fmt.Fprintf(w, "%d metric(s) regressed beyond budget.\n", len(reg))
+	} else {
+		fmt.Fprintf(w, "No significant regression beyond budget.\n")
+	}
+	return nil
+}
+
+func value(metric string, v float64) string {
+	switch metric {
+	case "rps":
+		return fmt.Sprintf("%.2f/s", v)
+	case "errors":
+		return fmt.Sprintf("%.2f%%", v)
+	default:
+		return time.Duration(v).Round(time.Microsecond).String()
+	}
+}
+
+func delta(metric string, d float64) string {
+	switch metric {
+	case "rps":
+		return fmt.Sprintf("%+.2f/s", d)
+	case "errors":
+		return fmt.Sprintf("%+.2f%%", d)
+	default:
+		return signed(time.Duration(d))
+	}
+}
+
+func budgetUnit(metric string) string {
+	if metric == "errors" {
+		return "pp"
+	}
+	return "%"
+}
+
+// signed formats a duration with an explicit sign.
+func signed(d time.Duration) string {
+	d = d.Round(time.Microsecond)
+	if d >= 0 {
+		return "+" + d.String()
+	}
+	return d.String()
+}
+
+func formatP(p float64) string {
+	if p < 0.001 {
+		return "p<0.001"
+	}
+	return fmt.Sprintf("p=%.3f", p)
+}
+
+func errorRate(res engine.Result) float64 {
+	if res.TotalRequests == 0 {
+		return 0
+	}
+	return 100 * float64(res.Failed) / float64(res.TotalRequests)
+}
+
+// intervalCounts returns the request counts of the full timeline
+// intervals, leaving out the final, usually partial, one.
+func intervalCounts(timeline []engine.Interval) []float64 {
+	if len(timeline) == 0 {
+		return nil
+	}
+	out := make([]float64, len(timeline)-1)
+	for i, iv := range timeline[:len(timeline)-1] {
+		out[i] = float64(iv.Requests)
+	}
+	return out
+}
+
+func sortedLatencies(l []time.Duration) []time.Duration {
+	s := slices.Clone(l)
+	slices.Sort(s)
+	return s
+}
+
+func floats(l []time.Duration) []float64 {
+	out := make([]float64, len(l))
+	for i, d := range l {
+		out[i] = float64(d)
+	}
+	return out
+}
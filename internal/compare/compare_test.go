@@ -0,0 +1,176 @@
+package compare
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"goperf/internal/engine"
+)
+
+func TestMannWhitney(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5}
+	b := []float64{6, 7, 8, 9, 10}
+	// U = 0: z = (12.5 - 0.5) / sqrt(25*11/12), p about 0.0122.
+	if p := mannWhitney(a, b); p < 0.011 || p > 0.013 {
+		t.Errorf("p = %g, want about 0.0122", p)
+	}
+	if p := mannWhitney(a, a); p < 0.9 {
+		t.Errorf("identical samples p = %g, want about 1", p)
+	}
+	if p := mannWhitney([]float64{1, 1, 1}, []float64{1, 1}); p != 1 {
+		t.Errorf("all ties p = %g, want 1", p)
+	}
+}
+
+func TestBootstrapDiffOfLargeSamples(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 0))
+	a, b := randomLatencies(4*maxResample, r), randomLatencies(4*maxResample, r)
+	for i := range b {
+		b[i] += 50 * time.Millisecond
+	}
+	// Subsampled, the interval still brackets the 50ms shift.
+	lo, hi := bootstrapDiff(a, b, 50, 0.95, 200, r)
+	if lo > 50*time.Millisecond || hi < 50*time.Millisecond || hi-lo > 20*time.Millisecond {
+		t.Errorf("interval = [%s, %s], want a narrow one around 50ms", lo, hi)
+	}
+
+	sub := subsample(a, r)
+	if len(sub) != maxResample || !slices.IsSorted(sub) {
+		t.Errorf("subsample of %d values is %d long, sorted = %v", len(a), len(sub), slices.IsSorted(sub))
+	}
+}
+
+func TestTwoProportions(t *testing.T) {
+	if p := twoProportions(10, 1000, 10, 1000); p != 1 {
+		t.Errorf("equal rates p = %g, want 1", p)
+	}
+	if p := twoProportions(10, 1000, 50, 1000); p > 0.001 {
+		t.Errorf("1%% vs 5%% p = %g, want < 0.001", p)
+	}
+	if p := twoProportions(0, 1000, 0, 1000); p != 1 {
+		t.Errorf("no failures p = %g, want 1", p)
+	}
+}
+
+// latencies returns n samples around mean with some spread.
+func latencies(r *rand.Rand, n int, mean time.Duration) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = mean + time.Duration(r.NormFloat64()*float64(mean)/10)
+	}
+	return out
+}
+
+func result(lats []time.Duration, failed int) engine.Result {
+	res := engine.Result{
+		TotalRequests: len(lats),
+		Succeeded:     len(lats) - failed,
+		Failed:        failed,
+		Latencies:     lats,
+		TotalDuration: 5 * time.Second,
+	}
+	for i := range 5 {
+		res.Timeline = append(res.Timeline, engine.Interval{Elapsed: time.Duration(i+1) * time.Second, Requests: len(lats) / 5})
+	}
+	return res
+}
+
+func TestCompare(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	base := result(latencies(r, 5000, 10*time.Millisecond), 5)
+	same := result(latencies(r, 5000, 10*time.Millisecond), 5)
+	slower := result(latencies(r, 5000, 12*time.Millisecond), 100)
+
+	budget, err := ParseBudget("5,errors=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Alpha: 0.05, Resamples: 500, Seed: 1}
+
+	if reg := Regressions(Compare(base, same, budget, opts)); len(reg) != 0 {
+		t.Errorf("same distribution regressed: %+v", reg)
+	}
+
+	rows := Compare(base, slower, budget, opts)
+	regressed := make(map[string]bool)
+	for _, row := range Regressions(rows) {
+		regressed[row.Metric] = true
+	}
+	for _, m := range []string{"average", "p50", "p90", "p99", "errors"} {
+		if !regressed[m] {
+			t.Errorf("%s did not regress: %+v", m, rows)
+		}
+	}
+	if regressed["rps"] {
+		t.Error("rps regressed with identical throughput")
+	}
+
+	// The same slowdown is within a generous budget.
+	loose, _ := ParseBudget("50,errors=5", nil)
+	if reg := Regressions(Compare(base, slower, loose, opts)); len(reg) != 0 {
+		t.Errorf("regressed within budget: %+v", reg)
+	}
+}
+
+func TestParseBudget(t *testing.T) {
+	def := Budget{"rps": 5, "average": 5, "p50": 5, "p90": 5, "p99": 5, "errors": 1}
+	tests := []struct {
+		in      string
+		want    Budget
+		wantErr bool
+	}{
+		{in: "10", want: Budget{"rps": 10, "average": 10, "p50": 10, "p90": 10, "p99": 10, "errors": 10}},
+		{in: "p99=20", want: Budget{"rps": 5, "average": 5, "p50": 5, "p90": 5, "p99": 20, "errors": 1}},
+		{in: "3, errors=0.5", want: Budget{"rps": 3, "average": 3, "p50": 3, "p90": 3, "p99": 3, "errors": 0.5}},
+		{in: "p95=10", wantErr: true},
+		{in: "fast", wantErr: true},
+		{in: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBudget(tt.in, def)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseBudget(%q) succeeded, want error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBudget(%q): %v", tt.in, err)
+			continue
+		}
+		for _, m := range Metrics {
+			if got[m] != tt.want[m] {
+				t.Errorf("ParseBudget(%q)[%s] = %g, want %g", tt.in, m, got[m], tt.want[m])
+			}
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	rows := []Row{
+		{Metric: "rps", Base: 100, New: 99, Change: -1, Evidence: "p=0.400", Budget: 5},
+		{Metric: "p99", Base: float64(10 * time.Millisecond), New: float64(15 * time.Millisecond), Change: 50,
+			Significant: true, Evidence: "95% CI +4ms..+6ms", Regressed: true, Budget: 5},
+	}
+	var buf bytes.Buffer
+	if err := Print(&buf, "base.goperf", "new.goperf", rows); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"Base:         base.goperf",
+		"noise, p=0.400",
+		"+5ms",
+		"+50.0%",
+		"significant, 95% CI +4ms..+6ms  REGRESSION (budget 5%)",
+		"1 metric(s) regressed beyond budget.",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
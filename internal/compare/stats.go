@@ -0,0 +1,151 @@
+package compare
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test
+// that a and b come from the same distribution, using the normal
+// approximation with a correction for ties.
+func mannWhitney(a, b []float64) float64 {
+	n1, n2 := len(a), len(b)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type obs struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]obs, 0, n1+n2)
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	slices.SortFunc(all, func(x, y obs) int {
+		switch {
+		case x.v < y.v:
+			return -1
+		case x.v > y.v:
+			return 1
+		}
+		return 0
+	})
+
+	// Rank with ties sharing their average rank.
+	var rankA, ties float64
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // ranks are 1-based: (i+1 + j) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n := float64(n1 + n2)
+	u := rankA - float64(n1)*float64(n1+1)/2
+	mean := float64(n1) * float64(n2) / 2
+	variance := float64(n1) * float64(n2) / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	return pValue(max(0, z))
+}
+
+// twoProportions returns the two-sided p-value of the z-test that x1 of n1
+// and x2 of n2 are drawn with the same probability.
+func twoProportions(x1, n1, x2, n2 int) float64 {
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	p := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(p * (1 - p) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 1
+	}
+	z := (float64(x2)/float64(n2) - float64(x1)/float64(n1)) / se
+	return pValue(math.Abs(z))
+}
+
+// pValue returns the two-sided p-value of a standard normal z score.
+func pValue(z float64) float64 {
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// maxResample caps the size of each bootstrap resample. Larger samples are
+// first reduced to a random subsample of this size, so the cost of each
+// resample does not grow with the run. That widens the interval and so
+// errs towards calling a difference noise.
+const maxResample = 50_000
+
+// bootstrapDiff returns a confidence interval, at the given level, for the
+// difference between the p-th percentiles of b and a (b minus a), from
+// iters bootstrap resamples. a and b must be sorted.
+func bootstrapDiff(a, b []time.Duration, p, level float64, iters int, r *rand.Rand) (lo, hi time.Duration) {
+	a, b = subsample(a, r), subsample(b, r)
+	countsA := make([]int32, len(a))
+	countsB := make([]int32, len(b))
+	diffs := make([]time.Duration, iters)
+	for i := range diffs {
+		diffs[i] = resamplePercentile(b, p, countsB, r) - resamplePercentile(a, p, countsA, r)
+	}
+	slices.Sort(diffs)
+	tail := (1 - level) / 2
+	lo = diffs[int(tail*float64(iters))]
+	hi = diffs[min(iters-1, int(math.Ceil((1-tail)*float64(iters)))-1)]
+	return lo, hi
+}
+
+// subsample returns sorted unchanged if it holds at most maxResample
+// values, and otherwise maxResample of them drawn at random, in order.
+func subsample(sorted []time.Duration, r *rand.Rand) []time.Duration {
+	n := len(sorted)
+	if n <= maxResample {
+		return sorted
+	}
+	idx := make([]int, maxResample)
+	for i := range idx {
+		idx[i] = r.IntN(n)
+	}
+	slices.Sort(idx)
+	sub := make([]time.Duration, maxResample)
+	for i, j := range idx {
+		sub[i] = sorted[j]
+	}
+	return sub
+}
+
+// resamplePercentile draws a resample of sorted with replacement and
+// returns its p-th percentile by the nearest-rank method. Because sorted
+// is in order, counting how often each index is drawn finds the
+// percentile without sorting the resample. counts is scratch space of
+// len(sorted).
+func resamplePercentile(sorted []time.Duration, p float64, counts []int32, r *rand.Rand) time.Duration {
+	clear(counts)
+	n := len(sorted)
+	for range n {
+		counts[r.IntN(n)]++
+	}
+	rank := max(1, int(math.Ceil(p/100*float64(n))))
+	var seen int
+	for i, c := range counts {
+		seen += int(c)
+		if seen >= rank {
+			return sorted[i]
+		}
+	}
+	return sorted[n-1]
+}
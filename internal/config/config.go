@@ -2,10 +2,20 @@
 package config
 
 import (
-	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"goperf/internal/arrival"
+	"goperf/internal/dist"
+	"goperf/internal/feeder"
 )
 
 // Config holds the load test parameters parsed from CLI flags.
@@ -15,8 +25,117 @@ type Config struct {
 	Concurrency int
 	Duration    time.Duration
 	Timeout     time.Duration
+
+	// Headers are "Key: Value" pairs added to every request. URL, header
+	// values and Body may contain {{...}} placeholders (see package tmpl).
+	Headers []string
+	Body    string
+
+	// Data is a CSV file whose columns templates reference as {{.column}}.
+	// DataMode is one of the feeder modes and DataStop ends a worker once
+	// the data is exhausted instead of recycling it.
+	Data     string
+	DataMode string
+	DataStop bool
+
+	// Flow is a JSON file of request steps each worker runs in order,
+	// replacing the single request described by URL, Method and Body.
+	// Step URLs starting with "/" are relative to URL.
+	Flow string
+
+	// Cookies gives each worker its own cookie jar so session cookies
+	// persist like a logged-in user's. SessionReset, when positive,
+	// discards a worker's cookies every that many iterations.
+	Cookies      bool
+	SessionReset int
+
+	// Think is the think-time distribution applied after each request
+	// (see package dist) and Pacing the interval at which each worker
+	// starts iterations.
+	Think  string
+	Pacing time.Duration
+
+	// Rate, if positive, caps the load at that many iterations per
+	// second (requests per second for a single request), arriving
+	// according to the Arrival process (see package arrival). Seed makes
+	// arrivals and all template, think-time and data randomness
+	// reproducible; zero picks a random seed.
+	Rate    float64
+	Arrival string
+	Seed    uint64
+
+	// AdaptiveP95, if positive, varies the number of active workers, up
+	// to Concurrency, to hold the P95 latency at that target, adjusting
+	// every AdaptInterval (see package adaptive).
+	AdaptiveP95   time.Duration
+	AdaptInterval time.Duration
+
+	// Save, if set, is the file the run is saved to (see package runfile)
+	// and Format the report format printed. Baseline is a saved run the
+	// markdown format compares against. CSVNoHeader leaves the header
+	// off the csv format so rows can be appended to an existing file, and
+	// CSVTimeline and CSVStatus are files it writes the timeline and
+	// status counts to.
+	Save        string
+	Format      string
+	Baseline    string
+	CSVNoHeader bool
+	CSVTimeline string
+	CSVStatus   string
+
+	// Repeat is the number of times the test is run, pausing for Cooldown
+	// between runs, to measure run-to-run variation.
+	Repeat   int
+	Cooldown time.Duration
+
+	// LogRequests, if set, is an NDJSON file receiving one line per
+	// request (see package reqlog). LogSample is the fraction of requests
+	// logged; LogFailed and LogSlow restrict the log to failed requests
+	// and requests slower than LogSlow.
+	LogRequests string
+	LogSample   float64
+	LogFailed   bool
+	LogSlow     time.Duration
+
+	// MetricsAddr, if set, is the address at which live metrics are
+	// served in Prometheus format on /metrics during the run.
+	MetricsAddr string
+
+	// Sinks are URLs of time series stores the live metrics are pushed
+	// to every SinkInterval (see package sink).
+	Sinks        []string
+	SinkInterval time.Duration
+
+	// Thresholds are pass/fail conditions on the run (see
+	// ParseThreshold); the run fails if any is not met.
+	Thresholds []string
+
+	// Slowest is the number of slowest requests listed in the report.
+	Slowest int
+
+	// Percentiles are the latency percentiles shown in the text report,
+	// in increasing order, and HistogramBuckets the upper bounds of its
+	// latency histogram. Empty buckets are chosen to span the latencies
+	// observed.
+	Percentiles      []float64
+	HistogramBuckets []time.Duration
+
+	// Trace sends a W3C traceparent header with every request (see
+	// package trace). OTLPEndpoint, if set, is the OTLP/HTTP traces URL
+	// client spans are exported to, and implies Trace.
+	Trace        bool
+	OTLPEndpoint string
+
+	// Proxy overrides the proxy taken from the HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY environment variables. Credentials may be supplied
+	// as URL user info.
+	Proxy string
 }
 
+// DefaultPercentiles are the latency percentiles reported unless
+// -percentiles is given.
+var DefaultPercentiles = []float64{50, 90, 99}
+
 var validMethods = map[string]bool{
 	"GET":     true,
 	"POST":    true,
@@ -27,44 +146,300 @@ var validMethods = map[string]bool{
 	"OPTIONS": true,
 }
 
+var validSinkSchemes = map[string]bool{
+	"influx":   true,
+	"influxs":  true,
+	"graphite": true,
+	"statsd":   true,
+}
+
+var validProxySchemes = map[string]bool{
+	"http":    true,
+	"https":   true,
+	"socks5":  true,
+	"socks5h": true,
+}
+
 // Parse parses CLI arguments into a Config, returning an error if
 // flags are invalid or required values are missing.
 func Parse(args []string) (Config, error) {
-	fs := flag.NewFlagSet("goperf", flag.ContinueOnError)
+	return ParseOutput(args, os.Stderr)
+}
 
+// ParseOutput is like Parse but writes usage and flag errors to out.
+func ParseOutput(args []string, out io.Writer) (Config, error) {
 	var cfg Config
-	fs.StringVar(&cfg.URL, "url", "", "Target URL to test (required)")
-	fs.StringVar(&cfg.Method, "method", "GET", "HTTP method")
-	fs.IntVar(&cfg.Concurrency, "concurrency", 10, "Number of concurrent workers")
-	fs.DurationVar(&cfg.Duration, "duration", 10*time.Second, "Test duration")
-	fs.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "Request timeout")
+	var file string
+	fs := newFlagSet("goperf run", &cfg)
+	fs.SetOutput(out)
+	fs.StringVar(&file, "config", "", "JSON file of flag settings, overridden by flags on the command line")
+	fs.StringVar(&cfg.Save, "save", "", "Save the run to this file for goperf report and goperf compare (gzipped unless it ends in .json)")
+	fs.StringVar(&cfg.Format, "format", "text", "Report format: text, json, csv, junit or markdown")
+	fs.StringVar(&cfg.Baseline, "baseline", "", "Saved run the markdown format shows changes from")
+	fs.BoolVar(&cfg.CSVNoHeader, "csv-no-header", false, "With the csv format, omit the header row to append to an existing file")
+	fs.StringVar(&cfg.CSVTimeline, "csv-timeline", "", "With the csv format, write the per-second timeline to this CSV file")
+	fs.StringVar(&cfg.CSVStatus, "csv-status", "", "With the csv format, write the count of each status code to this CSV file")
+	fs.IntVar(&cfg.Repeat, "repeat", 1, "Run the test this many times and report the variation between runs")
+	fs.DurationVar(&cfg.Cooldown, "cooldown", 0, "Pause between repeated runs")
 
-	if err := fs.Parse(args); err != nil {
+	src, err := parseArgs(fs, &file, args)
+	if err != nil {
 		return Config{}, err
 	}
 
 	if err := cfg.validate(); err != nil {
-		return Config{}, err
+		return Config{}, src.attribute(err)
+	}
+	if cfg.Repeat < 1 {
+		return Config{}, src.attribute(invalid("repeat", "repeat must be at least 1, got %d", cfg.Repeat))
+	}
+	if cfg.Cooldown < 0 {
+		return Config{}, src.attribute(invalid("cooldown", "cooldown must not be negative, got %s", cfg.Cooldown))
+	}
+	if cfg.Baseline != "" && cfg.Format != "markdown" {
+		return Config{}, src.attribute(invalid("baseline", "baseline applies only to the markdown format, got %q", cfg.Format))
+	}
+	if cfg.CSVNoHeader && cfg.Format != "csv" {
+		return Config{}, src.attribute(invalid("csv-no-header", "csv-no-header applies only to the csv format, got %q", cfg.Format))
+	}
+	if cfg.CSVTimeline != "" && cfg.Format != "csv" {
+		return Config{}, src.attribute(invalid("csv-timeline", "csv-timeline applies only to the csv format, got %q", cfg.Format))
+	}
+	if cfg.CSVStatus != "" && cfg.Format != "csv" {
+		return Config{}, src.attribute(invalid("csv-status", "csv-status applies only to the csv format, got %q", cfg.Format))
 	}
 
 	return cfg, nil
 }
 
+// newFlagSet returns a flag set that parses the load test flags into cfg.
+func newFlagSet(name string, cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\nFlags:\n", name)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.URL, "url", "", "Target URL to test (required unless -flow is given)")
+	fs.StringVar(&cfg.Method, "method", "GET", "HTTP method")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 10, "Number of concurrent workers")
+	fs.DurationVar(&cfg.Duration, "duration", 10*time.Second, "Test duration")
+	fs.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "Request timeout")
+	fs.Var((*stringList)(&cfg.Headers), "header", `Request header as "Key: Value" (repeatable)`)
+	fs.StringVar(&cfg.Body, "body", "", "Request body")
+	fs.StringVar(&cfg.Data, "data", "", "CSV file feeding {{.column}} template variables")
+	fs.StringVar(&cfg.DataMode, "data-mode", string(feeder.Sequential), "Data iteration: sequential, random or partition")
+	fs.BoolVar(&cfg.DataStop, "data-stop", false, "Stop when the data is exhausted instead of recycling it")
+	fs.StringVar(&cfg.Flow, "flow", "", "JSON file of multi-step flow to run instead of a single request")
+	fs.BoolVar(&cfg.Cookies, "cookies", false, "Keep a separate cookie jar for each worker")
+	fs.IntVar(&cfg.SessionReset, "session-reset", 0, "Clear each worker's cookies every N iterations (0 = never)")
+	fs.StringVar(&cfg.Think, "think", "", "Think time after each request: 500ms, uniform:MIN,MAX, normal:MEAN,STDDEV or exp:MEAN")
+	fs.DurationVar(&cfg.Pacing, "pacing", 0, "Start one iteration per worker every interval (0 = back to back)")
+	fs.Float64Var(&cfg.Rate, "rate", 0, "Target iterations per second across all workers (0 = as fast as possible)")
+	fs.StringVar(&cfg.Arrival, "arrival", "constant", "Arrival process at -rate: constant, poisson or burst:N")
+	fs.Uint64Var(&cfg.Seed, "seed", 0, "Random seed for reproducible runs (0 = random)")
+	fs.DurationVar(&cfg.AdaptiveP95, "adaptive-p95", 0, "Adjust active workers, up to -concurrency, to hold P95 latency at this target (0 = off)")
+	fs.DurationVar(&cfg.AdaptInterval, "adapt-interval", time.Second, "How often -adaptive-p95 adjusts the workers")
+	fs.StringVar(&cfg.LogRequests, "log-requests", "", "Write one JSON line per request to this file")
+	fs.Float64Var(&cfg.LogSample, "log-sample", 1, "Fraction of requests written to -log-requests")
+	fs.BoolVar(&cfg.LogFailed, "log-failures", false, "Only log failed requests (combined with -log-slow: failed or slow)")
+	fs.DurationVar(&cfg.LogSlow, "log-slow", 0, "Only log requests slower than this (0 = no latency filter)")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "Serve live Prometheus metrics on /metrics at this address, e.g. :9100")
+	fs.Var((*stringList)(&cfg.Sinks), "sink", "Push live metrics to influx://host:8086/write?db=NAME (influxs:// for HTTPS), graphite://host:2003 or statsd://host:8125 (repeatable)")
+	fs.DurationVar(&cfg.SinkInterval, "sink-interval", 10*time.Second, "Interval at which metrics are pushed to -sink")
+	fs.Var((*stringList)(&cfg.Thresholds), "threshold", "Fail the run unless a condition holds, e.g. p99<500ms, errors<1% or rps>=100 (repeatable)")
+	cfg.Percentiles = slices.Clone(DefaultPercentiles)
+	fs.Var((*percentileList)(&cfg.Percentiles), "percentiles", "Comma-separated latency percentiles to report, e.g. 50,90,99,99.9")
+	fs.Var((*durationList)(&cfg.HistogramBuckets), "histogram-buckets", "Comma-separated upper bounds of the latency histogram, e.g. 5ms,10ms,50ms (default chosen from the latencies)")
+	fs.IntVar(&cfg.Slowest, "slowest", 10, "Number of slowest requests to list in the report (0 = none)")
+	fs.BoolVar(&cfg.Trace, "trace", false, "Send a W3C traceparent header with a new trace ID on every request")
+	fs.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", "", "Export client spans to this OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces (implies -trace)")
+	fs.StringVar(&cfg.Proxy, "proxy", "", "Proxy URL (http://, https:// or socks5://, optionally with user:pass@); defaults to the environment")
+	return fs
+}
+
 func (c Config) validate() error {
-	if c.URL == "" {
-		return errors.New("url is required")
+	if c.URL == "" && c.Flow == "" {
+		return invalid("url", "url or flow is required")
 	}
 	if !validMethods[c.Method] {
-		return fmt.Errorf("unsupported HTTP method %q", c.Method)
+		return invalid("method", "unsupported HTTP method %q", c.Method)
 	}
 	if c.Concurrency <= 0 {
-		return fmt.Errorf("concurrency must be positive, got %d", c.Concurrency)
+		return invalid("concurrency", "concurrency must be positive, got %d", c.Concurrency)
 	}
 	if c.Duration <= 0 {
-		return fmt.Errorf("duration must be positive, got %s", c.Duration)
+		return invalid("duration", "duration must be positive, got %s", c.Duration)
 	}
 	if c.Timeout <= 0 {
-		return fmt.Errorf("timeout must be positive, got %s", c.Timeout)
+		return invalid("timeout", "timeout must be positive, got %s", c.Timeout)
+	}
+	if c.SessionReset < 0 {
+		return invalid("session-reset", "session-reset must not be negative, got %d", c.SessionReset)
+	}
+	if c.SessionReset > 0 && !c.Cookies {
+		return invalid("session-reset", "session-reset requires cookies")
+	}
+	if _, err := dist.Parse(c.Think); err != nil {
+		return invalid("think", "invalid think time: %w", err)
+	}
+	if c.Pacing < 0 {
+		return invalid("pacing", "pacing must not be negative, got %s", c.Pacing)
+	}
+	if !(c.Rate >= 0) || math.IsInf(c.Rate, 0) {
+		return invalid("rate", "rate must be a finite number, not negative, got %g", c.Rate)
+	}
+	if c.Rate > 0 {
+		if _, err := arrival.Parse(c.Arrival, c.Rate); err != nil {
+			return &fieldError{flag: "arrival", err: err}
+		}
+	}
+	if c.AdaptiveP95 < 0 {
+		return invalid("adaptive-p95", "adaptive-p95 must not be negative, got %s", c.AdaptiveP95)
+	}
+	if c.AdaptiveP95 > 0 && c.AdaptInterval <= 0 {
+		return invalid("adapt-interval", "adapt-interval must be positive, got %s", c.AdaptInterval)
+	}
+	if !(c.LogSample > 0 && c.LogSample <= 1) {
+		return invalid("log-sample", "log-sample must be above 0 and at most 1, got %g", c.LogSample)
+	}
+	if c.LogSlow < 0 {
+		return invalid("log-slow", "log-slow must not be negative, got %s", c.LogSlow)
+	}
+	for _, raw := range c.Sinks {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return invalid("sink", "invalid sink: %w", err)
+		}
+		if !validSinkSchemes[u.Scheme] {
+			return invalid("sink", "unsupported sink scheme %q, want influx, influxs, graphite or statsd", u.Scheme)
+		}
+		if u.Host == "" {
+			return invalid("sink", "sink %q has no host", u.Redacted())
+		}
+	}
+	if len(c.Sinks) > 0 && c.SinkInterval <= 0 {
+		return invalid("sink-interval", "sink-interval must be positive, got %s", c.SinkInterval)
+	}
+	for _, t := range c.Thresholds {
+		if _, err := ParseThreshold(t); err != nil {
+			return &fieldError{flag: "threshold", err: err}
+		}
+	}
+	for _, p := range c.Percentiles {
+		if !(p > 0 && p <= 100) {
+			return invalid("percentiles", "percentiles must be above 0 and at most 100, got %g", p)
+		}
+	}
+	for _, b := range c.HistogramBuckets {
+		if b <= 0 {
+			return invalid("histogram-buckets", "histogram-buckets must be positive, got %s", b)
+		}
+	}
+	if c.Slowest < 0 {
+		return invalid("slowest", "slowest must not be negative, got %d", c.Slowest)
+	}
+	if c.OTLPEndpoint != "" {
+		u, err := url.Parse(c.OTLPEndpoint)
+		if err != nil {
+			return invalid("otlp-endpoint", "invalid otlp-endpoint: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return invalid("otlp-endpoint", "otlp-endpoint %q must be an http or https URL", c.OTLPEndpoint)
+		}
+	}
+	if !slices.Contains(feeder.Modes, feeder.Mode(c.DataMode)) {
+		return invalid("data-mode", "unsupported data mode %q", c.DataMode)
+	}
+	for _, h := range c.Headers {
+		if _, _, err := SplitHeader(h); err != nil {
+			return &fieldError{flag: "header", err: err}
+		}
+	}
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil {
+			return invalid("proxy", "invalid proxy: %w", err)
+		}
+		if !validProxySchemes[u.Scheme] {
+			return invalid("proxy", "unsupported proxy scheme %q", u.Scheme)
+		}
+		if u.Host == "" {
+			return invalid("proxy", "proxy %q has no host", c.Proxy)
+		}
+	}
+	return nil
+}
+
+// SplitHeader splits a "Key: Value" header flag into its key and value.
+func SplitHeader(h string) (key, value string, err error) {
+	key, value, ok := strings.Cut(h, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid header %q, want \"Key: Value\"", h)
+	}
+	return key, strings.TrimSpace(value), nil
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// percentileList is a flag.Value holding a comma-separated list of
+// percentiles, kept sorted and without duplicates.
+type percentileList []float64
+
+func (l *percentileList) String() string {
+	parts := make([]string, len(*l))
+	for i, p := range *l {
+		parts[i] = strconv.FormatFloat(p, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *percentileList) Set(v string) error {
+	var ps []float64
+	for _, part := range strings.Split(v, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("invalid percentile %q", part)
+		}
+		ps = append(ps, p)
+	}
+	slices.Sort(ps)
+	*l = slices.Compact(ps)
+	return nil
+}
+
+// durationList is a flag.Value holding a comma-separated list of
+// durations, kept sorted and without duplicates.
+type durationList []time.Duration
+
+func (l *durationList) String() string {
+	parts := make([]string, len(*l))
+	for i, d := range *l {
+		parts[i] = d.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *durationList) Set(v string) error {
+	var ds []time.Duration
+	for _, part := range strings.Split(v, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", part)
+		}
+		ds = append(ds, d)
 	}
+	slices.Sort(ds)
+	*l = slices.Compact(ds)
 	return nil
 }
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// A config file is a JSON object whose keys are flag names, for example:
+//
+//	{
+//	  "url": "https://staging.example.com/api",
+//	  "concurrency": 50,
+//	  "duration": "1m",
+//	  "header": ["Authorization: Bearer ${API_TOKEN}"]
+//	}
+//
+// Repeatable flags take an array. ${NAME} in a string is replaced by the
+// environment variable NAME, which must be set. Flags given on the
+// command line override the file.
+
+// envRef matches a ${NAME} environment variable reference.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// parseArgs parses args into fs, then sets any flag not given on the
+// command line from the -config file named in path, if any. It returns
+// the flags that came from the file.
+func parseArgs(fs *flag.FlagSet, path *string, args []string) (*fileSource, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *path == "" {
+		return nil, nil
+	}
+
+	onCLI := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { onCLI[f.Name] = true })
+
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		return nil, err
+	}
+	src := &fileSource{path: *path, flags: make(map[string]bool)}
+	if err := src.apply(fs, data, onCLI); err != nil {
+		return nil, fmt.Errorf("%s: %w", *path, err)
+	}
+	return src, nil
+}
+
+// fileSource records which flags a config file set.
+type fileSource struct {
+	path  string
+	flags map[string]bool
+}
+
+func (s *fileSource) apply(fs *flag.FlagSet, data []byte, onCLI map[string]bool) error {
+	var fields map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return err
+	}
+
+	for name, raw := range fields {
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown field %q", name)
+		}
+		if onCLI[name] {
+			continue
+		}
+		values, err := fieldValues(f, raw)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", name, err)
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("field %q: invalid value %q: %w", name, v, err)
+			}
+		}
+		s.flags[name] = true
+	}
+	return nil
+}
+
+// fieldValues converts a JSON value into the strings to set the flag to:
+// one for a scalar, one per element of an array for a repeatable flag.
+func fieldValues(f *flag.Flag, raw json.RawMessage) ([]string, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if list, ok := v.([]any); ok {
+		if _, repeatable := f.Value.(*stringList); !repeatable {
+			return nil, errors.New("takes a single value, not an array")
+		}
+		out := make([]string, len(list))
+		for i, e := range list {
+			s, err := scalar(e)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			out[i] = s
+		}
+		return out, nil
+	}
+
+	s, err := scalar(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+func scalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return expandEnv(v)
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", errors.New("must not be null")
+	default:
+		return "", errors.New("must be a string, number or boolean")
+	}
+}
+
+// expandEnv replaces ${NAME} references with environment variables.
+func expandEnv(s string) (string, error) {
+	var err error
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v
+	})
+	return out, err
+}
+
+// attribute points a validation error at the config file when the
+// offending setting came from it.
+func (s *fileSource) attribute(err error) error {
+	var fe *fieldError
+	if s != nil && errors.As(err, &fe) && s.flags[fe.flag] {
+		return fmt.Errorf("%s: field %q: %w", s.path, fe.flag, err)
+	}
+	return err
+}
+
+// fieldError is a validation error in the setting of one flag.
+type fieldError struct {
+	flag string
+	err  error
+}
+
+func (e *fieldError) Error() string { return e.err.Error() }
+func (e *fieldError) Unwrap() error { return e.err }
+
+// invalid returns a validation error for the setting of flag.
+func invalid(flag, format string, args ...any) error {
+	return &fieldError{flag: flag, err: fmt.Errorf(format, args...)}
+}
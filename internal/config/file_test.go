@@ -0,0 +1,145 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "goperf.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseConfigFile(t *testing.T) {
+	t.Setenv("GOPERF_TEST_TOKEN", "s3cret")
+	path := writeConfig(t, `{
+		"url": "http://example.com",
+		"method": "POST",
+		"concurrency": 5,
+		"duration": "30s",
+		"cookies": true,
+		"rate": 12.5,
+		"header": ["Authorization: Bearer ${GOPERF_TEST_TOKEN}", "X-Env: test"]
+	}`)
+
+	got, err := Parse([]string{"-config", path, "-concurrency", "8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Config{
+		URL:           "http://example.com",
+		Method:        "POST",
+		Concurrency:   8, // the command line wins
+		Duration:      30 * time.Second,
+		Timeout:       10 * time.Second,
+		Headers:       []string{"Authorization: Bearer s3cret", "X-Env: test"},
+		DataMode:      "sequential",
+		Cookies:       true,
+		Rate:          12.5,
+		Arrival:       "constant",
+		AdaptInterval: time.Second,
+		LogSample:     1,
+		SinkInterval:  10 * time.Second,
+		Slowest:       10,
+		Percentiles:   []float64{50, 90, 99},
+		Format:        "text",
+		Repeat:        1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		args    []string
+		want    []string // substrings of the error
+		notWant string
+	}{
+		{
+			name:    "unknown field",
+			content: `{"url": "http://example.com", "concurency": 5}`,
+			want:    []string{"goperf.json", `unknown field "concurency"`},
+		},
+		{
+			name:    "invalid value",
+			content: `{"url": "http://example.com", "duration": 30}`,
+			want:    []string{"goperf.json", `field "duration"`, `invalid value "30"`},
+		},
+		{
+			name:    "array for single value",
+			content: `{"url": ["http://a", "http://b"]}`,
+			want:    []string{`field "url"`, "not an array"},
+		},
+		{
+			name:    "object value",
+			content: `{"url": "http://example.com", "body": {"a": 1}}`,
+			want:    []string{`field "body"`, "string, number or boolean"},
+		},
+		{
+			name:    "unset environment variable",
+			content: `{"url": "http://example.com", "header": ["Authorization: ${GOPERF_TEST_UNSET}"]}`,
+			want:    []string{`field "header"`, "GOPERF_TEST_UNSET is not set"},
+		},
+		{
+			name:    "malformed json",
+			content: `{"url": `,
+			want:    []string{"goperf.json"},
+		},
+		{
+			name:    "validation error from file",
+			content: `{"url": "http://example.com", "concurrency": 0}`,
+			want:    []string{"goperf.json", `field "concurrency"`, "must be positive"},
+		},
+		{
+			name:    "validation error from command line",
+			content: `{"url": "http://example.com"}`,
+			args:    []string{"-concurrency", "0"},
+			want:    []string{"concurrency must be positive"},
+			notWant: "goperf.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-config", writeConfig(t, tt.content)}, tt.args...)
+			_, err := Parse(args)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q does not mention %q", err, w)
+				}
+			}
+			if tt.notWant != "" && strings.Contains(err.Error(), tt.notWant) {
+				t.Errorf("error %q should not mention %q", err, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestParseSearchConfigFile(t *testing.T) {
+	path := writeConfig(t, `{"url": "http://example.com", "mode": "rate", "start": 100, "slo-p99": "250ms"}`)
+	cfg, s, err := ParseSearch([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.URL != "http://example.com" || s.Mode != "rate" || s.Start != 100 || s.P99 != 250*time.Millisecond {
+		t.Errorf("got %+v and %+v", cfg, s)
+	}
+
+	path = writeConfig(t, `{"url": "http://example.com", "precision": 2}`)
+	if _, _, err := ParseSearch([]string{"-config", path}); err == nil || !strings.Contains(err.Error(), `field "precision"`) {
+		t.Errorf("err = %v, want it to point at the precision field", err)
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// Search holds the parameters of a maximum-throughput search, which steps
+// load upward until a service level objective is missed.
+type Search struct {
+	// Mode is the load dimension searched: "concurrency" or "rate".
+	Mode string
+	// Start and Max bound the load levels tried.
+	Start float64
+	Max   float64
+	// P99 and ErrorRate (a percentage) make up the SLO each step must meet.
+	P99       time.Duration
+	ErrorRate float64
+	// Precision ends the search once the gap between the highest passing
+	// and lowest failing load is within this fraction of the former.
+	Precision float64
+}
+
+// ParseSearch parses the arguments of the find-max mode: the load test
+// flags, with -duration applying to each step, plus the search flags.
+func ParseSearch(args []string) (Config, Search, error) {
+	return ParseSearchOutput(args, os.Stderr)
+}
+
+// ParseSearchOutput is like ParseSearch but writes usage and flag
+// errors to out.
+func ParseSearchOutput(args []string, out io.Writer) (Config, Search, error) {
+	var cfg Config
+	var s Search
+	var file string
+	fs := newFlagSet("goperf find-max", &cfg)
+	fs.SetOutput(out)
+	fs.StringVar(&file, "config", "", "JSON file of flag settings, overridden by flags on the command line")
+	fs.StringVar(&s.Mode, "mode", "concurrency", "Load to step up: concurrency, or rate with -concurrency workers")
+	fs.Float64Var(&s.Start, "start", 1, "Load level of the first step")
+	fs.Float64Var(&s.Max, "max", 1024, "Highest load level to try")
+	fs.DurationVar(&s.P99, "slo-p99", 500*time.Millisecond, "Highest acceptable P99 latency")
+	fs.Float64Var(&s.ErrorRate, "slo-errors", 1, "Highest acceptable error rate in percent")
+	fs.Float64Var(&s.Precision, "precision", 0.05, "Stop once the knee is located within this fraction")
+
+	src, err := parseArgs(fs, &file, args)
+	if err != nil {
+		return Config{}, Search{}, err
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, Search{}, src.attribute(err)
+	}
+	if err := s.validate(); err != nil {
+		return Config{}, Search{}, src.attribute(err)
+	}
+	// The SLO decides each step, so a threshold would be silently ignored.
+	if len(cfg.Thresholds) > 0 {
+		return Config{}, Search{}, src.attribute(invalid("threshold", "threshold does not apply to find-max, use -slo-p99 and -slo-errors"))
+	}
+	return cfg, s, nil
+}
+
+func (s Search) validate() error {
+	if s.Mode != "concurrency" && s.Mode != "rate" {
+		return invalid("mode", "unsupported search mode %q", s.Mode)
+	}
+	if s.Start <= 0 {
+		return invalid("start", "start must be positive, got %g", s.Start)
+	}
+	if s.Max < s.Start {
+		return invalid("max", "max %g is below start %g", s.Max, s.Start)
+	}
+	if s.P99 <= 0 {
+		return invalid("slo-p99", "slo-p99 must be positive, got %s", s.P99)
+	}
+	if s.ErrorRate < 0 || s.ErrorRate > 100 {
+		return invalid("slo-errors", "slo-errors must be between 0 and 100, got %g", s.ErrorRate)
+	}
+	if s.Precision <= 0 || s.Precision >= 1 {
+		return invalid("precision", "precision must be between 0 and 1, got %g", s.Precision)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    Search
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			args: []string{"-url", "http://example.com"},
+			want: Search{Mode: "concurrency", Start: 1, Max: 1024, P99: 500 * time.Millisecond, ErrorRate: 1, Precision: 0.05},
+		},
+		{
+			name: "rate search",
+			args: []string{"-url", "http://example.com", "-mode", "rate", "-start", "50", "-max", "2000", "-slo-p99", "200ms", "-slo-errors", "0.5", "-precision", "0.1"},
+			want: Search{Mode: "rate", Start: 50, Max: 2000, P99: 200 * time.Millisecond, ErrorRate: 0.5, Precision: 0.1},
+		},
+		{name: "unknown mode", args: []string{"-url", "http://example.com", "-mode", "threads"}, wantErr: true},
+		{name: "zero start", args: []string{"-url", "http://example.com", "-start", "0"}, wantErr: true},
+		{name: "max below start", args: []string{"-url", "http://example.com", "-start", "10", "-max", "5"}, wantErr: true},
+		{name: "zero p99", args: []string{"-url", "http://example.com", "-slo-p99", "0s"}, wantErr: true},
+		{name: "error rate over 100", args: []string{"-url", "http://example.com", "-slo-errors", "101"}, wantErr: true},
+		{name: "precision of 1", args: []string{"-url", "http://example.com", "-precision", "1"}, wantErr: true},
+		{name: "threshold", args: []string{"-url", "http://example.com", "-threshold", "p99<1s"}, wantErr: true},
+		{name: "invalid load test flags", args: []string{"-start", "2"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, got, err := ParseSearch(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if cfg.URL != "http://example.com" {
+				t.Errorf("URL = %q, want http://example.com", cfg.URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold is a pass/fail condition on a run, such as "p99<500ms",
+// given with -threshold.
+type Threshold struct {
+	// Metric is rps, requests, errors (the failed percentage), avg, max,
+	// or p followed by a percentile, such as p99.9.
+	Metric string
+	// Op is <, <=, > or >=.
+	Op string
+	// Value is in req/s, requests or percent, or seconds for latencies.
+	Value float64
+}
+
+var thresholdExpr = regexp.MustCompile(`^\s*([a-z][a-z0-9.]*)\s*(<=|>=|<|>)\s*(\S+)\s*$`)
+
+// ParseThreshold parses a threshold expression such as "p99<500ms",
+// "errors<=1%" or "rps>=200".
+func ParseThreshold(s string) (Threshold, error) {
+	m := thresholdExpr.FindStringSubmatch(s)
+	if m == nil {
+		return Threshold{}, fmt.Errorf("invalid threshold %q, want METRIC<VALUE such as p99<500ms", s)
+	}
+	t := Threshold{Metric: m[1], Op: m[2]}
+	raw := m[3]
+	var err error
+	switch {
+	case t.IsLatency():
+		if p, ok := strings.CutPrefix(t.Metric, "p"); ok {
+			if n, perr := strconv.ParseFloat(p, 64); perr != nil || !(n > 0 && n <= 100) {
+				return Threshold{}, fmt.Errorf("invalid threshold %q: percentile must be above 0 and at most 100", s)
+			}
+		}
+		var d time.Duration
+		d, err = time.ParseDuration(raw)
+		t.Value = d.Seconds()
+	case t.Metric == "errors":
+		t.Value, err = strconv.ParseFloat(strings.TrimSuffix(raw, "%"), 64)
+	case t.Metric == "rps" || t.Metric == "requests":
+		t.Value, err = strconv.ParseFloat(raw, 64)
+	default:
+		return Threshold{}, fmt.Errorf("invalid threshold %q: unknown metric %q, want rps, requests, errors, avg, max or pN", s, t.Metric)
+	}
+	if err != nil {
+		return Threshold{}, fmt.Errorf("invalid threshold %q: bad value %q", s, raw)
+	}
+	return t, nil
+}
+
+// IsLatency reports whether the threshold applies to latency.
+func (t Threshold) IsLatency() bool {
+	return t.Metric == "avg" || t.Metric == "max" || strings.HasPrefix(t.Metric, "p")
+}
+
+// Percentile returns the percentile of a pN threshold.
+func (t Threshold) Percentile() (float64, bool) {
+	p, ok := strings.CutPrefix(t.Metric, "p")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(p, 64)
+	return n, err == nil
+}
+
+// Allows reports whether a measured value meets the threshold.
+func (t Threshold) Allows(v float64) bool {
+	switch t.Op {
+	case "<":
+		return v < t.Value
+	case "<=":
+		return v <= t.Value
+	case ">":
+		return v > t.Value
+	default:
+		return v >= t.Value
+	}
+}
+
+// Format formats a value in the threshold's unit.
+func (t Threshold) Format(v float64) string {
+	switch {
+	case t.IsLatency():
+		return time.Duration(v * float64(time.Second)).Round(time.Microsecond).String()
+	case t.Metric == "errors":
+		return strconv.FormatFloat(v, 'f', 2, 64) + "%"
+	case t.Metric == "rps":
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// String returns the threshold in canonical form, such as "p99 < 500ms".
+func (t Threshold) String() string {
+	v := strconv.FormatFloat(t.Value, 'g', -1, 64)
+	switch {
+	case t.IsLatency():
+		v = t.Format(t.Value)
+	case t.Metric == "errors":
+		v += "%"
+	}
+	return t.Metric + " " + t.Op + " " + v
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Threshold
+		str     string
+		wantErr bool
+	}{
+		{in: "p99<500ms", want: Threshold{"p99", "<", 0.5}, str: "p99 < 500ms"},
+		{in: " p99.9 <= 1s ", want: Threshold{"p99.9", "<=", 1}, str: "p99.9 <= 1s"},
+		{in: "avg<20ms", want: Threshold{"avg", "<", 0.02}, str: "avg < 20ms"},
+		{in: "errors<=1%", want: Threshold{"errors", "<=", 1}, str: "errors <= 1%"},
+		{in: "errors<0.5", want: Threshold{"errors", "<", 0.5}, str: "errors < 0.5%"},
+		{in: "rps>=200", want: Threshold{"rps", ">=", 200}, str: "rps >= 200"},
+		{in: "requests>1000", want: Threshold{"requests", ">", 1000}, str: "requests > 1000"},
+		{in: "p99=500ms", wantErr: true},
+		{in: "p0<1s", wantErr: true},
+		{in: "p101<1s", wantErr: true},
+		{in: "pnan<1s", wantErr: true},
+		{in: "p99<500", wantErr: true},
+		{in: "latency<1s", wantErr: true},
+		{in: "rps>lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseThreshold(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseThreshold(%q): expected an error, got %+v", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseThreshold(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want || got.String() != tt.str {
+			t.Errorf("ParseThreshold(%q) = %+v (%s), want %+v (%s)", tt.in, got, got, tt.want, tt.str)
+		}
+	}
+}
+
+func TestThresholdAllows(t *testing.T) {
+	tests := []struct {
+		expr string
+		v    float64
+		want bool
+	}{
+		{"p99<500ms", 0.499, true},
+		{"p99<500ms", 0.5, false},
+		{"p99<=500ms", 0.5, true},
+		{"rps>100", 100, false},
+		{"rps>=100", 100, true},
+	}
+	for _, tt := range tests {
+		th, err := ParseThreshold(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := th.Allows(tt.v); got != tt.want {
+			t.Errorf("%s allows %g = %v, want %v", tt.expr, tt.v, got, tt.want)
+		}
+	}
+}
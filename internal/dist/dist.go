@@ -0,0 +1,126 @@
+// Package dist provides random duration distributions for think times
+// and similar delays.
+//
+// Distributions are written as:
+//
+//	500ms                   always 500ms
+//	uniform:200ms,800ms     uniformly distributed between 200ms and 800ms
+//	normal:500ms,100ms      normal with mean 500ms and standard deviation
+//	                        100ms, truncated at zero
+//	exp:500ms               exponential with mean 500ms
+package dist
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// Dist is a distribution of non-negative durations.
+type Dist interface {
+	// Sample draws a duration using r.
+	Sample(r *rand.Rand) time.Duration
+	// String returns the distribution in the syntax accepted by Parse.
+	String() string
+}
+
+// Parse parses a distribution. An empty string yields a nil Dist.
+func Parse(s string) (Dist, error) {
+	if s == "" {
+		return nil, nil
+	}
+	kind, params, ok := strings.Cut(s, ":")
+	if !ok {
+		d, err := parseDurations(s, 1)
+		if err != nil {
+			return nil, err
+		}
+		return Constant(d[0]), nil
+	}
+
+	switch kind {
+	case "uniform":
+		d, err := parseDurations(params, 2)
+		if err != nil {
+			return nil, err
+		}
+		if d[1] < d[0] {
+			return nil, fmt.Errorf("uniform max %s is less than min %s", d[1], d[0])
+		}
+		return Uniform{Min: d[0], Max: d[1]}, nil
+	case "normal":
+		d, err := parseDurations(params, 2)
+		if err != nil {
+			return nil, err
+		}
+		return Normal{Mean: d[0], StdDev: d[1]}, nil
+	case "exp":
+		d, err := parseDurations(params, 1)
+		if err != nil {
+			return nil, err
+		}
+		return Exponential{Mean: d[0]}, nil
+	default:
+		return nil, fmt.Errorf("unknown distribution %q in %q", kind, s)
+	}
+}
+
+func parseDurations(s string, n int) ([]time.Duration, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("want %d duration(s), got %q", n, s)
+	}
+	out := make([]time.Duration, n)
+	for i, f := range fields {
+		d, err := time.ParseDuration(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("duration %s must not be negative", d)
+		}
+		out[i] = d
+	}
+	return out, nil
+}
+
+// Constant always yields the same duration.
+type Constant time.Duration
+
+func (c Constant) Sample(*rand.Rand) time.Duration { return time.Duration(c) }
+func (c Constant) String() string                  { return time.Duration(c).String() }
+
+// Uniform yields durations uniformly distributed in [Min, Max].
+type Uniform struct {
+	Min, Max time.Duration
+}
+
+func (u Uniform) Sample(r *rand.Rand) time.Duration {
+	return u.Min + time.Duration(r.Int64N(int64(u.Max-u.Min)+1))
+}
+
+func (u Uniform) String() string { return fmt.Sprintf("uniform:%s,%s", u.Min, u.Max) }
+
+// Normal yields normally distributed durations, truncated at zero.
+type Normal struct {
+	Mean, StdDev time.Duration
+}
+
+func (n Normal) Sample(r *rand.Rand) time.Duration {
+	return max(0, n.Mean+time.Duration(r.NormFloat64()*float64(n.StdDev)))
+}
+
+func (n Normal) String() string { return fmt.Sprintf("normal:%s,%s", n.Mean, n.StdDev) }
+
+// Exponential yields exponentially distributed durations, the gaps
+// between events of a Poisson process.
+type Exponential struct {
+	Mean time.Duration
+}
+
+func (e Exponential) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(e.Mean))
+}
+
+func (e Exponential) String() string { return fmt.Sprintf("exp:%s", e.Mean) }
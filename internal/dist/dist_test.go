@@ -0,0 +1,90 @@
+package dist
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Dist
+	}{
+		{in: "", want: nil},
+		{in: "500ms", want: Constant(500 * time.Millisecond)},
+		{in: "uniform:200ms,800ms", want: Uniform{Min: 200 * time.Millisecond, Max: 800 * time.Millisecond}},
+		{in: "normal:1s, 100ms", want: Normal{Mean: time.Second, StdDev: 100 * time.Millisecond}},
+		{in: "exp:250ms", want: Exponential{Mean: 250 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+		if got != nil {
+			if round, err := Parse(got.String()); err != nil || round != got {
+				t.Errorf("Parse(%q.String()) = %#v, %v; want round trip", tt.in, round, err)
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		"soon",
+		"-1s",
+		"uniform:1s",
+		"uniform:2s,1s",
+		"normal:1s",
+		"exp:1s,2s",
+		"gamma:1s",
+	} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func TestSampleMeans(t *testing.T) {
+	r := rand.New(rand.NewPCG(7, 7))
+	const n = 20000
+	tests := []struct {
+		d        Dist
+		mean     time.Duration
+		min, max time.Duration
+	}{
+		{d: Constant(time.Second), mean: time.Second, min: time.Second, max: time.Second},
+		{d: Uniform{Min: 100 * time.Millisecond, Max: 300 * time.Millisecond}, mean: 200 * time.Millisecond, min: 100 * time.Millisecond, max: 300 * time.Millisecond},
+		{d: Normal{Mean: 500 * time.Millisecond, StdDev: 50 * time.Millisecond}, mean: 500 * time.Millisecond, min: 0, max: time.Hour},
+		{d: Exponential{Mean: 100 * time.Millisecond}, mean: 100 * time.Millisecond, min: 0, max: time.Hour},
+	}
+	for _, tt := range tests {
+		var sum time.Duration
+		for range n {
+			s := tt.d.Sample(r)
+			if s < tt.min || s > tt.max {
+				t.Fatalf("%s sampled %s outside [%s, %s]", tt.d, s, tt.min, tt.max)
+			}
+			sum += s
+		}
+		mean := sum / n
+		if diff := mean - tt.mean; diff < -tt.mean/20 || diff > tt.mean/20 {
+			t.Errorf("%s sample mean = %s, want within 5%% of %s", tt.d, mean, tt.mean)
+		}
+	}
+}
+
+func TestNormalTruncatesAtZero(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	d := Normal{Mean: time.Millisecond, StdDev: time.Second}
+	for range 1000 {
+		if s := d.Sample(r); s < 0 {
+			t.Fatalf("sampled negative duration %s", s)
+		}
+	}
+}
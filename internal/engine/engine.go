@@ -3,12 +3,26 @@ package engine
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"goperf/internal/adaptive"
+	"goperf/internal/arrival"
 	"goperf/internal/config"
+	"goperf/internal/dist"
+	"goperf/internal/feeder"
+	"goperf/internal/metrics"
+	"goperf/internal/reqlog"
+	"goperf/internal/sink"
+	"goperf/internal/tmpl"
+	"goperf/internal/trace"
 	"goperf/internal/worker"
 )
 
@@ -21,34 +35,270 @@ type Result struct {
 	Errors        map[string]int
 	Latencies     []time.Duration
 	TotalDuration time.Duration
+
+	// Started is when the run began.
+	Started time.Time
+
+	// Statuses holds the status code of each of Latencies, or zero for a
+	// request that failed with an error, so that latency can be split by
+	// outcome and fast failures do not flatter the latency of successes
+	// (see LatencyByStatus, SuccessLatencies and FailureLatencies).
+	Statuses []uint16
+
+	// ProxyConnects holds the duration of each CONNECT tunnel opened
+	// through a proxy, kept apart from request latency so proxy overhead
+	// can be measured on its own.
+	ProxyConnects []time.Duration
+
+	// Steps breaks requests down by flow step, in flow order, and
+	// Transactions covers whole flow iterations. Both are empty unless a
+	// multi-step flow was run.
+	Steps        []Group
+	Transactions Group
+
+	// ThinkTime and PacingWait total the time workers spent idle between
+	// requests; they are never included in Latencies. PacingOverruns
+	// counts iterations that took longer than the pacing interval.
+	ThinkTime      time.Duration
+	PacingWait     time.Duration
+	PacingOverruns int
+
+	// DroppedArrivals counts arrivals at the target rate that found every
+	// worker busy. Seed is the random seed the run used.
+	DroppedArrivals int
+	Seed            uint64
+
+	// Timeline breaks the run down into TimelineInterval periods.
+	Timeline []Interval
+
+	// LoggedRequests and DroppedLogEntries count the request log entries
+	// written and those dropped because the log fell behind.
+	LoggedRequests    int
+	DroppedLogEntries int
+
+	// SinkPushes and SinkFailures count the writes to metric sinks and
+	// those that failed. SinkError holds the first error of each failing
+	// sink; a sink outage does not fail the run.
+	SinkPushes   int
+	SinkFailures int
+	SinkError    string
+
+	// ExportedSpans and DroppedSpans count the client spans exported to
+	// the OTLP endpoint and those lost because the exporter fell behind
+	// or the collector rejected them. SpanError holds the first export
+	// error.
+	ExportedSpans int
+	DroppedSpans  int
+	SpanError     string
+
+	// Slowest lists the slowest requests, slowest first, and Exemplars
+	// the latest request in each latency bucket of the metrics histogram
+	// (see Exemplar). Exemplars are only kept when -slowest, the request
+	// log or span export asks for request detail.
+	Slowest   []Request
+	Exemplars []Request
+
+	// Adaptive traces the number of active workers and the P95 latency
+	// over each control interval when concurrency is adjusted to hold a
+	// latency target.
+	Adaptive []adaptive.Sample
+}
+
+// Group aggregates the results of one flow step or of whole flow
+// iterations.
+type Group struct {
+	Name      string
+	Count     int
+	Failed    int
+	Latencies []time.Duration
+}
+
+// add records one result in the group.
+func (g *Group) add(rr worker.Result) {
+	g.Count++
+	if rr.Error != nil || rr.StatusCode >= 400 {
+		g.Failed++
+	}
+	g.Latencies = append(g.Latencies, rr.Duration)
 }
 
 // Run executes the load test with the given configuration, launching
 // concurrent workers and collecting their results into a single Result.
-func Run(cfg config.Config) Result {
+// It returns an error if the request cannot be prepared.
+func Run(cfg config.Config) (Result, error) {
+	var data *feeder.Feeder
+	scope := tmpl.NewScope()
+	if cfg.Data != "" {
+		var err error
+		data, err = feeder.Load(cfg.Data, feeder.Mode(cfg.DataMode), !cfg.DataStop)
+		if err != nil {
+			return Result{}, err
+		}
+		scope = tmpl.NewScope(data.Columns...)
+	}
+
+	steps, err := buildSteps(cfg, scope)
+	if err != nil {
+		return Result{}, err
+	}
+	think, err := dist.Parse(cfg.Think)
+	if err != nil {
+		return Result{}, err
+	}
+
 	transport := &http.Transport{
 		MaxIdleConnsPerHost: cfg.Concurrency,
 		DialContext: (&net.Dialer{
 			Timeout: cfg.Timeout,
 		}).DialContext,
+		Proxy:                  http.ProxyFromEnvironment,
+		OnProxyConnectResponse: worker.ObserveProxyConnect,
+	}
+	if cfg.Proxy != "" {
+		// Validated by config.Parse.
+		proxyURL, _ := url.Parse(cfg.Proxy)
+		transport.Proxy = http.ProxyURL(proxyURL)
 	}
 	client := &http.Client{
 		Timeout:   cfg.Timeout,
 		Transport: transport,
 	}
 
+	// Live metrics are served for as long as the run lasts and pushed to
+	// any sinks.
+	var live *metrics.Live
+	if cfg.MetricsAddr != "" || len(cfg.Sinks) > 0 {
+		live = metrics.NewLive(cfg.Concurrency, cfg.Rate)
+	}
+	if cfg.MetricsAddr != "" {
+		srv, err := metrics.Serve(cfg.MetricsAddr, live)
+		if err != nil {
+			return Result{}, fmt.Errorf("metrics: %w", err)
+		}
+		defer srv.Close()
+	}
+
 	results := make(chan worker.Result, cfg.Concurrency*100)
 
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+
+	var reqLog *reqlog.Logger
+	if cfg.LogRequests != "" {
+		reqLog, err = reqlog.Create(cfg.LogRequests, reqlog.Options{
+			Sample:     cfg.LogSample,
+			Failed:     cfg.LogFailed,
+			SlowerThan: cfg.LogSlow,
+		}, rand.New(rand.NewPCG(seed, math.MaxUint64)))
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	var spans *trace.Exporter
+	if cfg.OTLPEndpoint != "" {
+		spans = trace.NewExporter(cfg.OTLPEndpoint)
+	}
+
+	// Metric sinks are fed from the collection loop below.
+	var pusher *sink.Pusher
+	var push <-chan time.Time
+	if len(cfg.Sinks) > 0 {
+		sinks := make([]sink.Sink, 0, len(cfg.Sinks))
+		for _, raw := range cfg.Sinks {
+			sk, err := sink.Open(raw)
+			if err != nil {
+				for _, opened := range sinks {
+					opened.Close()
+				}
+				if reqLog != nil {
+					reqLog.Close()
+				}
+				if spans != nil {
+					spans.Close()
+				}
+				return Result{}, err
+			}
+			sinks = append(sinks, sk)
+		}
+		pusher = sink.NewPusher(sinks)
+		ticker := time.NewTicker(cfg.SinkInterval)
+		defer ticker.Stop()
+		push = ticker.C
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration)
 	defer cancel()
 
+	// At a target rate, workers wait for a ticket per iteration. The
+	// buffer lets idle workers absorb a burst; beyond that arrivals are
+	// dropped and counted.
+	var tickets chan struct{}
+	dropped := make(chan int, 1)
+	if cfg.Rate > 0 {
+		// Validated by config.Parse.
+		proc, _ := arrival.Parse(cfg.Arrival, cfg.Rate)
+		tickets = make(chan struct{}, cfg.Concurrency)
+		go func() {
+			dropped <- arrival.Run(ctx, proc, rand.New(rand.NewPCG(seed, 0)), tickets)
+		}()
+	}
+
+	// With a latency target, a controller admits workers through a gate
+	// and resizes it every interval.
+	var ctrl *adaptive.Controller
+	var gate *worker.Gate
+	var tick <-chan time.Time
+	if cfg.AdaptiveP95 > 0 {
+		ctrl = adaptive.New(cfg.AdaptiveP95, cfg.Concurrency)
+		gate = worker.NewGate(ctrl.Workers())
+		if live != nil {
+			live.SetActiveWorkers(ctrl.Workers())
+		}
+		ticker := time.NewTicker(cfg.AdaptInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var seq atomic.Uint64
 	var wg sync.WaitGroup
 	wg.Add(cfg.Concurrency)
 
-	for range cfg.Concurrency {
+	for id := range cfg.Concurrency {
+		w := &worker.Worker{
+			ID:     id,
+			Client: client,
+			Steps:  steps,
+			Seq:    &seq,
+
+			Cookies:      cfg.Cookies,
+			SessionReset: cfg.SessionReset,
+
+			Think:  think,
+			Pacing: cfg.Pacing,
+
+			Tickets: tickets,
+			Gate:    gate,
+
+			// Detail feeds the slowest requests and exemplars as well as
+			// the request log and spans; without them it is not worth an
+			// allocation per request.
+			Detail: cfg.Slowest > 0 || reqLog != nil || spans != nil,
+			Trace:  cfg.Trace || spans != nil,
+
+			Rand: rand.New(rand.NewPCG(seed, uint64(id)+1)),
+		}
+		if data != nil {
+			w.Data = data.Cursor(id, cfg.Concurrency)
+		}
+		if live != nil {
+			w.InFlight = &live.InFlight
+		}
 		go func() {
 			defer wg.Done()
-			worker.Run(ctx, client, cfg.Method, cfg.URL, results)
+			w.Run(ctx, results)
 		}()
 	}
 
@@ -59,24 +309,229 @@ func Run(cfg config.Config) Result {
 
 	start := time.Now()
 	res := Result{
+		Started:     start,
 		StatusCodes: make(map[int]int),
 		Errors:      make(map[string]int),
 	}
-	for rr := range results {
-		res.TotalRequests++
-		if rr.Error != nil {
-			res.Failed++
-			res.Errors[rr.Error.Error()]++
-		} else if rr.StatusCode >= 400 {
-			res.Failed++
-			res.StatusCodes[rr.StatusCode]++
-		} else {
-			res.Succeeded++
-			res.StatusCodes[rr.StatusCode]++
+	if len(steps) > 1 {
+		res.Steps = make([]Group, len(steps))
+		for i, s := range steps {
+			res.Steps[i].Name = s.Name
+		}
+		res.Transactions.Name = "transaction"
+	}
+	timeline := time.NewTicker(TimelineInterval)
+	defer timeline.Stop()
+	// Where the current timeline interval starts in res.Latencies, and
+	// the failures counted before it.
+	var tlMark, tlFailed int
+	flush := func() {
+		res.Timeline = append(res.Timeline, newInterval(time.Since(start), res.Latencies[tlMark:], res.Failed-tlFailed))
+		tlMark, tlFailed = len(res.Latencies), res.Failed
+	}
+
+	slow := slowest{n: cfg.Slowest}
+	examples := newExemplars()
+
+	mark := 0 // start of the current control interval in res.Latencies
+collect:
+	for {
+		select {
+		case rr, ok := <-results:
+			if !ok {
+				break collect
+			}
+			res.record(rr)
+			if reqLog != nil && !rr.Transaction {
+				reqLog.Log(rr, steps[rr.Step].Name)
+			}
+			// Requests that failed before being sent have no detail.
+			if rr.Detail != nil {
+				slow.add(rr)
+				examples.add(rr)
+				if spans != nil {
+					spans.Export(span(rr, steps))
+				}
+			}
+			if live != nil {
+				live.Observe(rr)
+			}
+		case <-timeline.C:
+			flush()
+		case <-push:
+			pusher.Push(live.Snapshot())
+		case <-tick:
+			window := res.Latencies[mark:]
+			mark = len(res.Latencies)
+			active := gate.Limit()
+			p95 := ctrl.Observe(window)
+			res.Adaptive = append(res.Adaptive, adaptive.Sample{
+				Elapsed:  time.Since(start),
+				Workers:  active,
+				P95:      p95,
+				Requests: len(window),
+			})
+			gate.SetLimit(ctrl.Workers())
+			if live != nil {
+				live.SetActiveWorkers(ctrl.Workers())
+			}
 		}
-		res.Latencies = append(res.Latencies, rr.Duration)
+	}
+	if tlMark < len(res.Latencies) {
+		flush()
 	}
 	res.TotalDuration = time.Since(start)
+	res.Seed = seed
+	stepName := func(i int) string {
+		if len(steps) > 1 {
+			return steps[i].Name
+		}
+		return ""
+	}
+	res.Slowest = slow.list(stepName)
+	res.Exemplars = examples.list(stepName)
+	if tickets != nil {
+		// Workers may all stop early when data runs out, so make sure
+		// the scheduler has stopped too.
+		cancel()
+		res.DroppedArrivals = <-dropped
+	}
+
+	if spans != nil {
+		var err error
+		res.ExportedSpans, res.DroppedSpans, err = spans.Close()
+		if err != nil {
+			res.SpanError = err.Error()
+		}
+	}
+
+	if pusher != nil {
+		// A last push so the sinks see the final totals.
+		var err error
+		res.SinkPushes, res.SinkFailures, err = pusher.Close(live.Snapshot())
+		if err != nil {
+			res.SinkError = err.Error()
+		}
+	}
+
+	if reqLog != nil {
+		var err error
+		res.LoggedRequests, res.DroppedLogEntries, err = reqLog.Close()
+		if err != nil {
+			return res, fmt.Errorf("writing request log: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+// HasStatuses reports whether Statuses covers Latencies, so latency can
+// be split by outcome. It does not for results built without them, such
+// as runs saved by older versions.
+func (r *Result) HasStatuses() bool {
+	return len(r.Statuses) == len(r.Latencies)
+}
+
+// LatencyByStatus splits the latencies of the requests that received a
+// response by status code.
+func (r *Result) LatencyByStatus() map[int][]time.Duration {
+	out := make(map[int][]time.Duration)
+	if !r.HasStatuses() {
+		return out
+	}
+	for i, code := range r.Statuses {
+		if code != 0 {
+			out[int(code)] = append(out[int(code)], r.Latencies[i])
+		}
+	}
+	return out
+}
+
+// ErrorLatencies returns the latencies of the requests that failed with
+// an error.
+func (r *Result) ErrorLatencies() []time.Duration {
+	return r.latenciesWhere(func(code uint16) bool { return code == 0 })
+}
+
+// SuccessLatencies returns the latencies of the requests that succeeded.
+func (r *Result) SuccessLatencies() []time.Duration {
+	return r.latenciesWhere(func(code uint16) bool { return code != 0 && code < 400 })
+}
+
+// FailureLatencies returns the latencies of the requests that failed,
+// with an error or a status of 400 or above.
+func (r *Result) FailureLatencies() []time.Duration {
+	return r.latenciesWhere(func(code uint16) bool { return code == 0 || code >= 400 })
+}
+
+// latenciesWhere returns the latencies of the requests whose status code,
+// zero for errors, satisfies keep.
+func (r *Result) latenciesWhere(keep func(code uint16) bool) []time.Duration {
+	if !r.HasStatuses() {
+		return nil
+	}
+	var out []time.Duration
+	for i, code := range r.Statuses {
+		if keep(code) {
+			out = append(out, r.Latencies[i])
+		}
+	}
+	return out
+}
 
-	return res
+// span describes a request for export as a client span, named after its
+// flow step or, for a single request, its method.
+func span(rr worker.Result, steps []worker.Step) trace.Span {
+	d := rr.Detail
+	s := trace.Span{
+		Context: rr.Trace,
+		Name:    d.Method,
+		Start:   d.Start,
+		End:     d.Start.Add(rr.Duration),
+		Worker:  d.Worker,
+		Method:  d.Method,
+		URL:     d.URL,
+		Status:  rr.StatusCode,
+	}
+	if len(steps) > 1 {
+		s.Name = steps[rr.Step].Name
+	}
+	if rr.Error != nil {
+		s.Error = rr.Error.Error()
+	}
+	return s
+}
+
+// record adds one worker result to the totals.
+func (r *Result) record(rr worker.Result) {
+	if rr.Transaction {
+		r.Transactions.add(rr)
+		return
+	}
+	r.ThinkTime += rr.Think
+	r.PacingWait += rr.Pacing
+	if rr.Overran {
+		r.PacingOverruns++
+	}
+	if r.Steps != nil {
+		r.Steps[rr.Step].add(rr)
+	}
+	r.TotalRequests++
+	if rr.Error != nil {
+		r.Failed++
+		r.Errors[rr.Error.Error()]++
+		r.Statuses = append(r.Statuses, 0)
+	} else {
+		if rr.StatusCode >= 400 {
+			r.Failed++
+		} else {
+			r.Succeeded++
+		}
+		r.StatusCodes[rr.StatusCode]++
+		r.Statuses = append(r.Statuses, uint16(rr.StatusCode))
+	}
+	r.Latencies = append(r.Latencies, rr.Duration)
+	if rr.ProxyConnect > 0 {
+		r.ProxyConnects = append(r.ProxyConnects, rr.ProxyConnect)
+	}
 }
@@ -1,8 +1,20 @@
 package engine
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -23,7 +35,10 @@ func TestRunCompletesAndAggregates(t *testing.T) {
 		Timeout:     5 * time.Second,
 	}
 
-	res := Run(cfg)
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	if res.TotalRequests == 0 {
 		t.Fatal("expected at least one request")
@@ -56,7 +71,10 @@ func TestRunCountsFailures(t *testing.T) {
 		Timeout:     5 * time.Second,
 	}
 
-	res := Run(cfg)
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	if res.Succeeded != 0 {
 		t.Errorf("expected 0 succeeded, got %d", res.Succeeded)
@@ -65,3 +83,769 @@ func TestRunCountsFailures(t *testing.T) {
 		t.Error("expected failures for 503 responses")
 	}
 }
+
+func TestRunUsesConfiguredProxy(t *testing.T) {
+	var proxied atomic.Int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute target URL.
+		if r.URL.Host == "target.invalid" {
+			proxied.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	cfg := config.Config{
+		URL:         "http://target.invalid/",
+		Method:      "GET",
+		Concurrency: 1,
+		Duration:    100 * time.Millisecond,
+		Timeout:     5 * time.Second,
+		Proxy:       proxy.URL,
+	}
+
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if res.Succeeded == 0 {
+		t.Fatalf("expected successes through proxy, got errors %v", res.Errors)
+	}
+	if proxied.Load() == 0 {
+		t.Error("proxy never received a request for the target")
+	}
+}
+
+func TestRunFeedsDataAndStopsWhenExhausted(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path+" "+r.Header.Get("Authorization")]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	data := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(data, []byte("id,token\n1,aaa\n2,bbb\n3,ccc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{
+		URL:         srv.URL + "/users/{{.id}}",
+		Method:      "GET",
+		Headers:     []string{"Authorization: Bearer {{.token}}"},
+		Concurrency: 2,
+		Duration:    5 * time.Second,
+		Timeout:     5 * time.Second,
+		Data:        data,
+		DataMode:    "partition",
+		DataStop:    true,
+	}
+
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if res.TotalRequests != 3 {
+		t.Errorf("total requests = %d, want one per data row", res.TotalRequests)
+	}
+	if res.TotalDuration >= cfg.Duration {
+		t.Errorf("run lasted %s, want it to stop once data was exhausted", res.TotalDuration)
+	}
+	want := map[string]int{"/users/1 Bearer aaa": 1, "/users/2 Bearer bbb": 1, "/users/3 Bearer ccc": 1}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("server saw %v, want %v", seen, want)
+	}
+}
+
+func TestRunRejectsUnknownVariable(t *testing.T) {
+	cfg := config.Config{
+		URL:         "http://example.com/{{.id}}",
+		Method:      "GET",
+		Concurrency: 1,
+		Duration:    time.Second,
+		Timeout:     time.Second,
+	}
+	if _, err := Run(cfg); err == nil {
+		t.Fatal("expected error for variable without a data file")
+	}
+}
+
+func TestRunFlow(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token": "t-1"}`))
+	})
+	mux.HandleFunc("GET /items", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"items": [{"id": 42}]}`))
+	})
+	mux.HandleFunc("GET /items/42", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	flowFile := filepath.Join(t.TempDir(), "flow.json")
+	def := `{"steps": [
+		{"name": "login", "method": "POST", "url": "/login", "extract": [{"var": "token", "from": "json", "expr": "$.token"}]},
+		{"name": "list", "url": "/items", "headers": {"Authorization": "Bearer {{.token}}"},
+		 "extract": [{"var": "item", "from": "json", "expr": "$.items[0].id"}]},
+		{"name": "fetch", "url": "/items/{{.item}}"}
+	]}`
+	if err := os.WriteFile(flowFile, []byte(def), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{
+		URL:         srv.URL,
+		Method:      "GET",
+		Concurrency: 2,
+		Duration:    200 * time.Millisecond,
+		Timeout:     5 * time.Second,
+		Flow:        flowFile,
+	}
+
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Steps) != 3 {
+		t.Fatalf("got %d step groups, want 3", len(res.Steps))
+	}
+	var stepTotal int
+	for i, name := range []string{"login", "list", "fetch"} {
+		g := res.Steps[i]
+		if g.Name != name {
+			t.Errorf("step %d name = %q, want %q", i, g.Name, name)
+		}
+		if g.Count == 0 {
+			t.Errorf("step %q never ran", name)
+		}
+		stepTotal += g.Count
+	}
+	if stepTotal != res.TotalRequests {
+		t.Errorf("step counts sum to %d, want total requests %d", stepTotal, res.TotalRequests)
+	}
+	if _, ok := res.StatusCodes[http.StatusUnauthorized]; ok {
+		t.Errorf("extracted token not used by later steps: %v", res.StatusCodes)
+	}
+	tx := res.Transactions
+	if tx.Count == 0 || tx.Count-tx.Failed == 0 {
+		t.Errorf("expected successful transactions, got %+v", tx)
+	}
+	if len(tx.Latencies) != tx.Count {
+		t.Errorf("transaction latencies %d != count %d", len(tx.Latencies), tx.Count)
+	}
+}
+
+func TestRunFlowRejectsForwardReference(t *testing.T) {
+	flowFile := filepath.Join(t.TempDir(), "flow.json")
+	def := `{"steps": [
+		{"url": "http://example.com/{{.token}}"},
+		{"url": "http://example.com/login", "extract": [{"var": "token", "from": "header", "expr": "X-Token"}]}
+	]}`
+	if err := os.WriteFile(flowFile, []byte(def), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{Method: "GET", Concurrency: 1, Duration: time.Second, Timeout: time.Second, Flow: flowFile}
+	if _, err := Run(cfg); err == nil {
+		t.Fatal("expected error for variable used before it is extracted")
+	}
+}
+
+func TestRunFlowRejectsRelativeURLWithoutBase(t *testing.T) {
+	flowFile := filepath.Join(t.TempDir(), "flow.json")
+	if err := os.WriteFile(flowFile, []byte(`{"steps": [{"name": "list", "url": "/items"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{Method: "GET", Concurrency: 1, Duration: time.Second, Timeout: time.Second, Flow: flowFile}
+	_, err := Run(cfg)
+	if err == nil || !strings.Contains(err.Error(), `step "list": relative URL "/items" needs -url`) {
+		t.Fatalf("err = %v, want the relative URL rejected", err)
+	}
+}
+
+func TestRunGivesEachWorkerItsOwnSession(t *testing.T) {
+	var sessions atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("sid"); err != nil {
+			sessions.Add(1)
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s", Path: "/"})
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.Config{
+		URL:         srv.URL,
+		Method:      "GET",
+		Concurrency: 3,
+		Duration:    100 * time.Millisecond,
+		Timeout:     5 * time.Second,
+		Cookies:     true,
+	}
+	if _, err := Run(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := sessions.Load(); got != int64(cfg.Concurrency) {
+		t.Errorf("server started %d sessions, want one per worker (%d)", got, cfg.Concurrency)
+	}
+}
+
+func TestRunAtTargetRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cfg := config.Config{
+		URL:         srv.URL,
+		Method:      "GET",
+		Concurrency: 4,
+		Duration:    300 * time.Millisecond,
+		Timeout:     5 * time.Second,
+		Rate:        50,
+		Arrival:     "poisson",
+		Seed:        7,
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 50/s for 300ms is 15 arrivals on average.
+	if res.TotalRequests < 5 || res.TotalRequests > 30 {
+		t.Errorf("sent %d requests, want about 15 at 50/s for 300ms", res.TotalRequests)
+	}
+	if res.Seed != 7 {
+		t.Errorf("Seed = %d, want 7", res.Seed)
+	}
+}
+
+func TestRunIsReproducibleWithSeed(t *testing.T) {
+	// run records request paths on a fresh server so stragglers from one
+	// run cannot leak into the next.
+	run := func() []string {
+		var mu sync.Mutex
+		var paths []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			paths = append(paths, r.URL.Path)
+			mu.Unlock()
+		}))
+		defer srv.Close()
+
+		cfg := config.Config{
+			URL:         srv.URL + "/{{randInt 1 1000000}}/{{randString 8}}",
+			Method:      "GET",
+			Concurrency: 1,
+			Duration:    50 * time.Millisecond,
+			Timeout:     5 * time.Second,
+			Seed:        99,
+		}
+		if _, err := Run(cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		srv.Close()
+		return paths
+	}
+
+	first, second := run(), run()
+	n := min(len(first), len(second), 5)
+	if n == 0 {
+		t.Fatal("no requests recorded")
+	}
+	if !reflect.DeepEqual(first[:n], second[:n]) {
+		t.Errorf("same seed produced different requests:\n%v\n%v", first[:n], second[:n])
+	}
+}
+
+func TestRunAdaptsConcurrencyToLatencyTarget(t *testing.T) {
+	// Latency grows with the number of requests in flight, so only a
+	// handful of workers fit within the target.
+	var inflight atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		time.Sleep(time.Duration(n) * 5 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	cfg := config.Config{
+		URL:           srv.URL,
+		Method:        "GET",
+		Concurrency:   64,
+		Duration:      time.Second,
+		Timeout:       5 * time.Second,
+		AdaptiveP95:   30 * time.Millisecond,
+		AdaptInterval: 50 * time.Millisecond,
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Adaptive) < 10 {
+		t.Fatalf("recorded %d control intervals, want about 20", len(res.Adaptive))
+	}
+	if w := res.Adaptive[0].Workers; w != 1 {
+		t.Errorf("started with %d workers, want 1", w)
+	}
+	var backedOff bool
+	for i, s := range res.Adaptive {
+		if s.Workers >= cfg.Concurrency {
+			t.Errorf("interval %d ran %d workers, want the target to hold it well below %d", i, s.Workers, cfg.Concurrency)
+		}
+		if i > 0 && s.Workers < res.Adaptive[i-1].Workers {
+			backedOff = true
+		}
+	}
+	if !backedOff {
+		t.Error("expected the controller to reduce workers after exceeding the target")
+	}
+}
+
+func TestRunRecordsTimeline(t *testing.T) {
+	var n atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1)%4 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.Config{
+		URL:         srv.URL,
+		Method:      "GET",
+		Concurrency: 2,
+		Duration:    1300 * time.Millisecond,
+		Timeout:     5 * time.Second,
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// One full interval and a partial one for the last 300ms.
+	if len(res.Timeline) != 2 {
+		t.Fatalf("timeline has %d intervals, want 2: %+v", len(res.Timeline), res.Timeline)
+	}
+	var requests, failed int
+	for i, iv := range res.Timeline {
+		requests += iv.Requests
+		failed += iv.Failed
+		if iv.Requests > 0 && (iv.P50 <= 0 || iv.P50 > iv.P90 || iv.P90 > iv.P99) {
+			t.Errorf("interval %d percentiles out of order: %+v", i, iv)
+		}
+	}
+	if requests != res.TotalRequests || failed != res.Failed {
+		t.Errorf("timeline totals %d requests, %d failed; result has %d, %d", requests, failed, res.TotalRequests, res.Failed)
+	}
+	if got := res.Timeline[0].Elapsed.Round(100 * time.Millisecond); got != time.Second {
+		t.Errorf("first interval ends at %s, want 1s", got)
+	}
+}
+
+func TestRunWritesRequestLog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "requests.ndjson")
+	cfg := config.Config{
+		URL:         srv.URL + "/item",
+		Method:      "GET",
+		Concurrency: 2,
+		Duration:    100 * time.Millisecond,
+		Timeout:     5 * time.Second,
+		LogRequests: path,
+		LogSample:   1,
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.LoggedRequests != res.TotalRequests || res.DroppedLogEntries != 0 {
+		t.Errorf("logged %d, dropped %d of %d requests", res.LoggedRequests, res.DroppedLogEntries, res.TotalRequests)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != res.LoggedRequests {
+		t.Fatalf("log has %d lines, want %d", len(lines), res.LoggedRequests)
+	}
+	var e struct {
+		URL    string  `json:"url"`
+		Status int     `json:"status"`
+		Bytes  int64   `json:"bytes"`
+		TTFB   float64 `json:"ttfb_ms"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatalf("first line is not JSON: %v", err)
+	}
+	if e.URL != srv.URL+"/item" || e.Status != 200 || e.Bytes != 2 || e.TTFB <= 0 {
+		t.Errorf("first entry = %+v", e)
+	}
+}
+
+func TestRunServesMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// Reserve a free port, then hand it to the run.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := config.Config{
+		URL:         srv.URL,
+		Method:      "GET",
+		Concurrency: 2,
+		Duration:    400 * time.Millisecond,
+		Timeout:     5 * time.Second,
+		MetricsAddr: addr,
+	}
+	scraped := make(chan string, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			scraped <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		scraped <- string(body)
+	}()
+	if _, err := Run(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	body := <-scraped
+	for _, want := range []string{`goperf_requests_total{code="200"} `, "goperf_active_workers 2\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("scrape missing %q:\n%s", want, body)
+		}
+	}
+
+	// The endpoint goes away with the run.
+	if _, err := http.Get("http://" + addr + "/metrics"); err == nil {
+		t.Error("metrics still served after the run")
+	}
+
+	// An address that cannot be bound fails the run up front.
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cfg.MetricsAddr = ln.Addr().String()
+	if _, err := Run(cfg); err == nil {
+		t.Error("expected an error when the metrics address is in use")
+	}
+}
+
+func TestRunPushesToSinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var writes []string
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		writes = append(writes, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	cfg := config.Config{
+		URL:          srv.URL,
+		Method:       "GET",
+		Concurrency:  2,
+		Duration:     350 * time.Millisecond,
+		Timeout:      5 * time.Second,
+		Sinks:        []string{"influx://" + strings.TrimPrefix(influx.URL, "http://")},
+		SinkInterval: 100 * time.Millisecond,
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Three interval pushes and the final one.
+	if len(writes) < 3 || res.SinkPushes != len(writes) || res.SinkFailures != 0 {
+		t.Fatalf("%d writes received, %d pushes, %d failed", len(writes), res.SinkPushes, res.SinkFailures)
+	}
+	want := fmt.Sprintf("goperf_request_duration_seconds_count value=%di ", res.TotalRequests)
+	if last := writes[len(writes)-1]; !strings.Contains(last, want) {
+		t.Errorf("last write missing %q:\n%s", want, last)
+	}
+}
+
+func TestRunSurvivesUnreachableSink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := config.Config{
+		URL:          srv.URL,
+		Method:       "GET",
+		Concurrency:  1,
+		Duration:     150 * time.Millisecond,
+		Timeout:      5 * time.Second,
+		Sinks:        []string{"graphite://" + addr},
+		SinkInterval: 50 * time.Millisecond,
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Succeeded == 0 || res.SinkFailures == 0 || res.SinkFailures != res.SinkPushes || !strings.Contains(res.SinkError, "graphite") {
+		t.Errorf("%d succeeded, %d of %d pushes failed: %q", res.Succeeded, res.SinkFailures, res.SinkPushes, res.SinkError)
+	}
+}
+
+func TestRunExportsSpans(t *testing.T) {
+	var traced atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("traceparent") != "" {
+			traced.Add(1)
+		}
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var exported int
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []json.RawMessage
+				}
+			}
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		exported += len(req.ResourceSpans[0].ScopeSpans[0].Spans)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	cfg := config.Config{
+		URL:          srv.URL,
+		Method:       "GET",
+		Concurrency:  2,
+		Duration:     100 * time.Millisecond,
+		Timeout:      5 * time.Second,
+		OTLPEndpoint: collector.URL + "/v1/traces",
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Requests cut off at the end of the run may reach the server but
+	// count as failed or go unrecorded.
+	if int(traced.Load()) < res.Succeeded {
+		t.Errorf("%d of %d requests carried a traceparent", traced.Load(), res.Succeeded)
+	}
+	if res.ExportedSpans+res.DroppedSpans != res.TotalRequests || exported != res.ExportedSpans || res.SpanError != "" {
+		t.Errorf("exported %d (collector saw %d), dropped %d of %d requests: %s",
+			res.ExportedSpans, exported, res.DroppedSpans, res.TotalRequests, res.SpanError)
+	}
+}
+
+func TestRunKeepsURLPasswordsOutOfRequestDetail(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	var mu sync.Mutex
+	var spans []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		spans = append(spans, body...)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	logFile := filepath.Join(t.TempDir(), "requests.ndjson")
+	cfg := config.Config{
+		URL:          strings.Replace(srv.URL, "http://", "http://bob:hunter2@", 1),
+		Method:       "GET",
+		Concurrency:  1,
+		Duration:     100 * time.Millisecond,
+		Timeout:      5 * time.Second,
+		Slowest:      5,
+		LogRequests:  logFile,
+		LogSample:    1,
+		OTLPEndpoint: collector.URL + "/v1/traces",
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Slowest) == 0 || !strings.Contains(res.Slowest[0].URL, "bob:xxxxx@") {
+		t.Fatalf("slowest requests = %+v, want the redacted URL", res.Slowest)
+	}
+
+	encoded, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for name, out := range map[string][]byte{"result": encoded, "request log": logged, "spans": spans} {
+		if len(out) == 0 {
+			t.Errorf("%s is empty", name)
+		}
+		if bytes.Contains(out, []byte("hunter2")) {
+			t.Errorf("%s contains the URL password", name)
+		}
+	}
+}
+
+func TestRunListsSlowestRequests(t *testing.T) {
+	var n atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1)%10 == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.Config{
+		URL:         srv.URL + "/item",
+		Method:      "GET",
+		Concurrency: 2,
+		Duration:    300 * time.Millisecond,
+		Timeout:     5 * time.Second,
+		Slowest:     5,
+		Trace:       true,
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Slowest) != 5 {
+		t.Fatalf("got %d slowest requests, want 5", len(res.Slowest))
+	}
+	if want := slices.Max(res.Latencies); res.Slowest[0].Latency != want {
+		t.Errorf("slowest = %s, want %s", res.Slowest[0].Latency, want)
+	}
+	if !slices.IsSortedFunc(res.Slowest, func(a, b Request) int { return int(b.Latency - a.Latency) }) {
+		t.Errorf("slowest requests not sorted: %+v", res.Slowest)
+	}
+	if r := res.Slowest[0]; r.URL != srv.URL+"/item" || r.Method != "GET" || r.TraceID == "" || r.Time.IsZero() {
+		t.Errorf("slowest request = %+v", r)
+	}
+
+	// The slowest requests land in the 25ms bucket, the rest well below.
+	if len(res.Exemplars) < 2 {
+		t.Fatalf("got %d exemplars, want at least 2", len(res.Exemplars))
+	}
+	for _, d := range []time.Duration{res.Slowest[0].Latency, res.Latencies[0]} {
+		e, ok := res.Exemplar(d)
+		if !ok || bucket(e.Latency) != bucket(d) {
+			t.Errorf("exemplar for %s = %+v, %v", d, e, ok)
+		}
+	}
+
+	// Without a consumer, workers skip the per-request detail.
+	cfg.Slowest, cfg.Trace = 0, false
+	res, err = Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Slowest) != 0 || len(res.Exemplars) != 0 {
+		t.Errorf("got %d slowest and %d exemplars with -slowest 0, want none", len(res.Slowest), len(res.Exemplars))
+	}
+}
+
+func TestRunSplitsLatencyByOutcome(t *testing.T) {
+	var n atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch n.Add(1) % 3 {
+		case 0:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 1:
+			time.Sleep(2 * time.Millisecond)
+		case 2:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.Config{
+		URL:         srv.URL,
+		Method:      "GET",
+		Concurrency: 2,
+		Duration:    200 * time.Millisecond,
+		Timeout:     5 * time.Second,
+	}
+	res, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !res.HasStatuses() {
+		t.Fatalf("%d statuses for %d latencies", len(res.Statuses), len(res.Latencies))
+	}
+	byStatus := res.LatencyByStatus()
+	for code, count := range res.StatusCodes {
+		if got := len(byStatus[code]); got != count {
+			t.Errorf("[%d] has %d latencies for %d responses", code, got, count)
+		}
+	}
+	if errs := res.ErrorLatencies(); len(errs) != sumCounts(res.Errors) {
+		t.Errorf("%d error latencies for %d errors", len(errs), sumCounts(res.Errors))
+	}
+	success, failure := res.SuccessLatencies(), res.FailureLatencies()
+	if len(success) != res.Succeeded || len(failure) != res.Failed {
+		t.Errorf("%d success and %d failure latencies for %d succeeded, %d failed",
+			len(success), len(failure), res.Succeeded, res.Failed)
+	}
+	if len(byStatus[500]) == 0 || len(byStatus[200]) == 0 || len(byStatus[201]) == 0 {
+		t.Errorf("expected 200, 201 and 500 latencies, got status codes %v", res.StatusCodes)
+	}
+}
+
+func sumCounts(m map[string]int) int {
+	var n int
+	for _, c := range m {
+		n += c
+	}
+	return n
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"container/heap"
+	"slices"
+	"time"
+
+	"goperf/internal/metrics"
+	"goperf/internal/worker"
+)
+
+// Request identifies one request for the slowest-requests list and the
+// percentile exemplars, with enough detail to find it in server logs or
+// traces.
+type Request struct {
+	Time    time.Time
+	Latency time.Duration
+	Worker  int
+	Step    string // the flow step, empty for a single request
+	Method  string
+	URL     string
+	Status  int
+	Error   string
+	Reused  bool
+	TraceID string
+}
+
+// newRequest describes a result, which must carry a Detail. stepName
+// names its flow step.
+func newRequest(rr worker.Result, stepName func(int) string) Request {
+	d := rr.Detail
+	r := Request{
+		Time:    d.Start,
+		Latency: rr.Duration,
+		Worker:  d.Worker,
+		Step:    stepName(rr.Step),
+		Method:  d.Method,
+		URL:     d.URL,
+		Status:  rr.StatusCode,
+		Reused:  d.Reused,
+	}
+	if rr.Error != nil {
+		r.Error = rr.Error.Error()
+	}
+	if rr.Trace.IsValid() {
+		r.TraceID = rr.Trace.Trace()
+	}
+	return r
+}
+
+// slowest keeps the n slowest results seen in a min-heap, so each result
+// costs one comparison against the fastest kept. Results are only turned
+// into Requests at the end of the run.
+type slowest struct {
+	n    int
+	heap resultHeap
+}
+
+func (s *slowest) add(rr worker.Result) {
+	switch {
+	case s.n <= 0:
+	case len(s.heap) < s.n:
+		heap.Push(&s.heap, rr)
+	case rr.Duration > s.heap[0].Duration:
+		s.heap[0] = rr
+		heap.Fix(&s.heap, 0)
+	}
+}
+
+// list returns the requests kept, slowest first.
+func (s *slowest) list(stepName func(int) string) []Request {
+	out := make([]Request, len(s.heap))
+	for i, rr := range s.heap {
+		out[i] = newRequest(rr, stepName)
+	}
+	slices.SortFunc(out, func(a, b Request) int { return int(b.Latency - a.Latency) })
+	return out
+}
+
+type resultHeap []worker.Result
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h[i].Duration < h[j].Duration }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x any)        { *h = append(*h, x.(worker.Result)) }
+func (h *resultHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// exemplars keeps the latest result in each latency bucket of the
+// metrics histogram. Empty buckets hold a result without Detail.
+type exemplars []worker.Result
+
+func newExemplars() exemplars {
+	return make(exemplars, len(metrics.Buckets)+1)
+}
+
+func (e exemplars) add(rr worker.Result) {
+	e[bucket(rr.Duration)] = rr
+}
+
+// list returns the exemplars held, fastest bucket first.
+func (e exemplars) list(stepName func(int) string) []Request {
+	var out []Request
+	for _, rr := range e {
+		if rr.Detail != nil {
+			out = append(out, newRequest(rr, stepName))
+		}
+	}
+	return out
+}
+
+// bucket returns the index of the histogram bucket holding d.
+func bucket(d time.Duration) int {
+	i, _ := slices.BinarySearch(metrics.Buckets, d.Seconds())
+	return i
+}
+
+// Exemplar returns a request from the same latency bucket as d, such as
+// a percentile, if one was recorded.
+func (r *Result) Exemplar(d time.Duration) (Request, bool) {
+	b := bucket(d)
+	for _, e := range r.Exemplars {
+		if bucket(e.Latency) == b {
+			return e, true
+		}
+	}
+	return Request{}, false
+}
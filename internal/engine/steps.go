@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"goperf/internal/config"
+	"goperf/internal/flow"
+	"goperf/internal/tmpl"
+	"goperf/internal/worker"
+)
+
+// buildSteps compiles the requests each worker sends: the single request
+// described by the CLI flags, or the steps of cfg.Flow. Variables
+// extracted by a step are declared in scope so later steps can use them.
+func buildSteps(cfg config.Config, scope *tmpl.Scope) ([]worker.Step, error) {
+	header := make(http.Header)
+	for _, h := range cfg.Headers {
+		key, value, err := config.SplitHeader(h)
+		if err != nil {
+			return nil, err
+		}
+		header.Add(key, value)
+	}
+
+	if cfg.Flow == "" {
+		req, err := worker.NewRequest(cfg.Method, cfg.URL, header, cfg.Body, scope)
+		if err != nil {
+			return nil, err
+		}
+		return []worker.Step{{Request: req}}, nil
+	}
+
+	def, err := flow.Load(cfg.Flow)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]worker.Step, 0, len(def.Steps))
+	for _, sd := range def.Steps {
+		step, err := buildStep(cfg, sd, header, scope)
+		if err != nil {
+			return nil, fmt.Errorf("%s: step %q: %w", cfg.Flow, sd.Name, err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// buildStep compiles one flow step. Step headers are added to the headers
+// given on the command line, and URLs starting with "/" are relative to
+// cfg.URL.
+func buildStep(cfg config.Config, sd flow.StepDef, header http.Header, scope *tmpl.Scope) (worker.Step, error) {
+	method := sd.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	rawURL := sd.URL
+	if strings.HasPrefix(rawURL, "/") {
+		if cfg.URL == "" {
+			return worker.Step{}, fmt.Errorf("relative URL %q needs -url as its base", rawURL)
+		}
+		rawURL = strings.TrimSuffix(cfg.URL, "/") + rawURL
+	}
+	h := header.Clone()
+	for k, v := range sd.Headers {
+		h.Set(k, v)
+	}
+
+	req, err := worker.NewRequest(method, rawURL, h, sd.Body, scope)
+	if err != nil {
+		return worker.Step{}, err
+	}
+	step := worker.Step{Name: sd.Name, Request: req}
+
+	// Declared after compiling the request so a step cannot use its own
+	// extractions.
+	for _, ed := range sd.Extract {
+		e, err := flow.NewExtractor(ed, scope.Declare(ed.Var))
+		if err != nil {
+			return worker.Step{}, err
+		}
+		step.Extract = append(step.Extract, e)
+	}
+	return step, nil
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// TimelineInterval is the length of each Interval in Result.Timeline.
+const TimelineInterval = time.Second
+
+// Interval summarises the requests completed during one interval of a run.
+type Interval struct {
+	// Elapsed is the time from the start of the run to the end of the
+	// interval. The last interval of a run may be shorter than the rest.
+	Elapsed  time.Duration
+	Requests int
+	Failed   int
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+}
+
+// newInterval summarises the latencies recorded during an interval.
+func newInterval(elapsed time.Duration, latencies []time.Duration, failed int) Interval {
+	iv := Interval{Elapsed: elapsed, Requests: len(latencies), Failed: failed}
+	if len(latencies) == 0 {
+		return iv
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	at := func(p float64) time.Duration {
+		return sorted[max(0, int(math.Ceil(p/100*float64(len(sorted))))-1)]
+	}
+	iv.P50, iv.P90, iv.P99 = at(50), at(90), at(99)
+	return iv
+}
@@ -0,0 +1,148 @@
+// Package feeder supplies per-request data rows loaded from a CSV file.
+package feeder
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sync/atomic"
+)
+
+// Mode selects how rows are handed out to workers.
+type Mode string
+
+const (
+	// Sequential hands rows out in file order, shared across all workers.
+	Sequential Mode = "sequential"
+	// Random picks a uniformly random row, with replacement, for every
+	// request. Without recycling the data counts as exhausted after as
+	// many requests as there are rows.
+	Random Mode = "random"
+	// Partition gives each worker a disjoint slice of the rows, worker k
+	// receiving rows k, k+N, k+2N, ... for N workers. Workers beyond the
+	// number of rows receive no data and stop immediately.
+	Partition Mode = "partition"
+)
+
+// Modes lists the valid iteration modes.
+var Modes = []Mode{Sequential, Random, Partition}
+
+// Feeder holds the rows of a data file. It is shared by all workers, each
+// of which reads through its own Cursor.
+type Feeder struct {
+	Columns []string
+
+	rows    [][]string
+	mode    Mode
+	recycle bool
+	drawn   atomic.Uint64 // rows handed out in Sequential and Random modes
+}
+
+// Load reads a CSV file whose first record names the columns. When
+// recycle is false the feeder reports exhaustion once every row has been
+// used, otherwise it wraps around.
+func Load(path string, mode Mode, recycle bool) (*Feeder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fd, err := Read(f, mode, recycle)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return fd, nil
+}
+
+// Read is like Load but reads CSV data from r.
+func Read(r io.Reader, mode Mode, recycle bool) (*Feeder, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("data file needs a header row and at least one data row")
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range records[0] {
+		if c == "" {
+			return nil, errors.New("empty column name in header row")
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("duplicate column %q in header row", c)
+		}
+		seen[c] = true
+	}
+
+	switch mode {
+	case Sequential, Random, Partition:
+	default:
+		return nil, fmt.Errorf("unknown data mode %q", mode)
+	}
+
+	return &Feeder{
+		Columns: records[0],
+		rows:    records[1:],
+		mode:    mode,
+		recycle: recycle,
+	}, nil
+}
+
+// Len returns the number of data rows.
+func (f *Feeder) Len() int {
+	return len(f.rows)
+}
+
+// Cursor returns the iteration state for worker id out of workers.
+func (f *Feeder) Cursor(id, workers int) *Cursor {
+	return &Cursor{f: f, id: id, workers: workers}
+}
+
+// Cursor hands out rows to a single worker. It is not safe for concurrent use.
+type Cursor struct {
+	f       *Feeder
+	id      int
+	workers int
+	n       int // rows handed out by this cursor in Partition mode
+}
+
+// Next returns the row for the worker's next request, or false when the
+// data is exhausted and the feeder does not recycle. The returned slice
+// must not be modified.
+func (c *Cursor) Next(r *rand.Rand) ([]string, bool) {
+	f := c.f
+	total := len(f.rows)
+
+	switch f.mode {
+	case Partition:
+		// Rows owned by this worker: id, id+workers, ...
+		owned := (total - c.id + c.workers - 1) / c.workers
+		if owned <= 0 {
+			return nil, false
+		}
+		if c.n >= owned && !f.recycle {
+			return nil, false
+		}
+		i := c.id + (c.n%owned)*c.workers
+		c.n++
+		return f.rows[i], true
+
+	case Random:
+		if !f.recycle && f.drawn.Add(1) > uint64(total) {
+			return nil, false
+		}
+		return f.rows[r.IntN(total)], true
+
+	default: // Sequential
+		n := f.drawn.Add(1) - 1
+		if n >= uint64(total) && !f.recycle {
+			return nil, false
+		}
+		return f.rows[n%uint64(total)], true
+	}
+}
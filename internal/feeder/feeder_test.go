@@ -0,0 +1,124 @@
+package feeder
+
+import (
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const users = "id,token\n1,a\n2,b\n3,c\n4,d\n5,e\n"
+
+func mustRead(t *testing.T, mode Mode, recycle bool) *Feeder {
+	t.Helper()
+	f, err := Read(strings.NewReader(users), mode, recycle)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return f
+}
+
+// drain returns the id column of every row the cursor yields, up to max.
+func drain(c *Cursor, max int) []string {
+	r := rand.New(rand.NewPCG(1, 1))
+	var ids []string
+	for range max {
+		row, ok := c.Next(r)
+		if !ok {
+			break
+		}
+		ids = append(ids, row[0])
+	}
+	return ids
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(path, []byte(users), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path, Sequential, true)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(f.Columns, []string{"id", "token"}) {
+		t.Errorf("Columns = %v, want [id token]", f.Columns)
+	}
+	if f.Len() != 5 {
+		t.Errorf("Len = %d, want 5", f.Len())
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.csv"), Sequential, true); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"header only":      "id,token\n",
+		"empty column":     "id,\n1,2\n",
+		"duplicate column": "id,id\n1,2\n",
+		"ragged rows":      "id,token\n1\n",
+	} {
+		if _, err := Read(strings.NewReader(data), Sequential, true); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := Read(strings.NewReader(users), "shuffled", true); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestSequential(t *testing.T) {
+	f := mustRead(t, Sequential, false)
+	a, b := f.Cursor(0, 2), f.Cursor(1, 2)
+
+	got := append(drain(a, 2), drain(b, 10)...)
+	if want := []string{"1", "2", "3", "4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %v, want %v shared across cursors", got, want)
+	}
+	if _, ok := a.Next(nil); ok {
+		t.Error("expected exhaustion without recycling")
+	}
+
+	recycled := drain(mustRead(t, Sequential, true).Cursor(0, 1), 7)
+	if want := []string{"1", "2", "3", "4", "5", "1", "2"}; !reflect.DeepEqual(recycled, want) {
+		t.Errorf("recycled rows = %v, want %v", recycled, want)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	f := mustRead(t, Partition, false)
+	want := [][]string{{"1", "3", "5"}, {"2", "4"}}
+	for id, w := range want {
+		if got := drain(f.Cursor(id, 2), 10); !reflect.DeepEqual(got, w) {
+			t.Errorf("worker %d rows = %v, want %v", id, got, w)
+		}
+	}
+
+	recycled := drain(mustRead(t, Partition, true).Cursor(1, 2), 5)
+	if w := []string{"2", "4", "2", "4", "2"}; !reflect.DeepEqual(recycled, w) {
+		t.Errorf("recycled partition = %v, want %v", recycled, w)
+	}
+
+	if got := drain(f.Cursor(6, 8), 1); len(got) != 0 {
+		t.Errorf("worker without rows got %v", got)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	got := drain(mustRead(t, Random, false).Cursor(0, 1), 100)
+	if len(got) != 5 {
+		t.Errorf("random without recycling yielded %d rows, want 5", len(got))
+	}
+
+	seen := map[string]bool{}
+	for _, id := range drain(mustRead(t, Random, true).Cursor(0, 1), 200) {
+		seen[id] = true
+	}
+	if len(seen) != 5 {
+		t.Errorf("random with recycling saw %d distinct rows, want 5", len(seen))
+	}
+}
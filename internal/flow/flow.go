@@ -0,0 +1,241 @@
+// Package flow loads multi-step user flow definitions and extracts values
+// from responses for use by later steps.
+//
+// A flow file is JSON:
+//
+//	{
+//	  "steps": [
+//	    {
+//	      "name": "login",
+//	      "method": "POST",
+//	      "url": "/login",
+//	      "headers": {"Content-Type": "application/json"},
+//	      "body": "{\"user\": \"{{.user}}\"}",
+//	      "extract": [{"var": "token", "from": "json", "expr": "$.token"}]
+//	    },
+//	    {
+//	      "name": "list items",
+//	      "url": "/items",
+//	      "headers": {"Authorization": "Bearer {{.token}}"}
+//	    }
+//	  ]
+//	}
+//
+// Extracted variables are referenced in later steps as {{.name}}.
+package flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Definition is a parsed flow file.
+type Definition struct {
+	Steps []StepDef `json:"steps"`
+}
+
+// StepDef describes one request of a flow.
+type StepDef struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Extract []ExtractDef      `json:"extract"`
+}
+
+// ExtractDef describes a value to capture from a step's response.
+type ExtractDef struct {
+	// Var names the variable the value is stored in.
+	Var string `json:"var"`
+	// From is the source: "json", "regex", "header" or "cookie".
+	From string `json:"from"`
+	// Expr is a JSON path such as $.items[0].id, a regular expression
+	// (the first capture group is used if present), or a header or
+	// cookie name.
+	Expr string `json:"expr"`
+}
+
+// Load reads and parses a flow file.
+func Load(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	def, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return def, nil
+}
+
+// Parse parses a JSON flow definition and checks that it has at least one
+// step and that every step has a URL and a unique name. Unnamed steps are
+// named after their position.
+func Parse(data []byte) (*Definition, error) {
+	var def Definition
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&def); err != nil {
+		return nil, err
+	}
+	if len(def.Steps) == 0 {
+		return nil, errors.New("flow has no steps")
+	}
+
+	names := make(map[string]bool)
+	for i := range def.Steps {
+		s := &def.Steps[i]
+		if s.Name == "" {
+			s.Name = "step " + strconv.Itoa(i+1)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate step name %q", s.Name)
+		}
+		names[s.Name] = true
+		if s.URL == "" {
+			return nil, fmt.Errorf("step %q has no url", s.Name)
+		}
+	}
+	return &def, nil
+}
+
+// Extractor captures a value from a response into a template variable slot.
+type Extractor struct {
+	Var  string
+	Slot int
+
+	from string
+	path []string       // json
+	re   *regexp.Regexp // regex
+	name string         // header, cookie
+}
+
+// NewExtractor compiles an extraction that stores its value in slot.
+func NewExtractor(def ExtractDef, slot int) (*Extractor, error) {
+	if def.Var == "" {
+		return nil, errors.New("extract has no var")
+	}
+	e := &Extractor{Var: def.Var, Slot: slot, from: def.From}
+	switch def.From {
+	case "json":
+		path, err := parsePath(def.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", def.Var, err)
+		}
+		e.path = path
+	case "regex":
+		re, err := regexp.Compile(def.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", def.Var, err)
+		}
+		e.re = re
+	case "header", "cookie":
+		if def.Expr == "" {
+			return nil, fmt.Errorf("extract %s: missing %s name", def.Var, def.From)
+		}
+		e.name = def.Expr
+	default:
+		return nil, fmt.Errorf("extract %s: unknown source %q", def.Var, def.From)
+	}
+	return e, nil
+}
+
+// NeedsBody reports whether the extractor reads the response body.
+func (e *Extractor) NeedsBody() bool {
+	return e.from == "json" || e.from == "regex"
+}
+
+// Extract returns the value captured from resp, whose body has already
+// been read into body when NeedsBody is true.
+func (e *Extractor) Extract(resp *http.Response, body []byte) (string, error) {
+	switch e.from {
+	case "json":
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return "", fmt.Errorf("response is not JSON: %w", err)
+		}
+		return lookup(v, e.path)
+	case "regex":
+		m := e.re.FindSubmatch(body)
+		if m == nil {
+			return "", fmt.Errorf("no match for %s", e.re)
+		}
+		if len(m) > 1 {
+			return string(m[1]), nil
+		}
+		return string(m[0]), nil
+	case "header":
+		if v := resp.Header.Get(e.name); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("no %s header", e.name)
+	default: // cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == e.name {
+				return c.Value, nil
+			}
+		}
+		return "", fmt.Errorf("no %s cookie", e.name)
+	}
+}
+
+// parsePath splits a JSON path like $.items[0].id into its keys and
+// indices: ["items", "0", "id"]. A path may start with an index, as in
+// $[0].id, to read from a top-level array.
+func parsePath(expr string) ([]string, error) {
+	p := strings.NewReplacer("[", ".", "]", "").Replace(strings.TrimPrefix(expr, "$"))
+	p = strings.TrimPrefix(p, ".")
+	if p == "" {
+		return nil, fmt.Errorf("empty JSON path %q", expr)
+	}
+	parts := strings.Split(p, ".")
+	for _, k := range parts {
+		if k == "" {
+			return nil, fmt.Errorf("malformed JSON path %q", expr)
+		}
+	}
+	return parts, nil
+}
+
+func lookup(v any, path []string) (string, error) {
+	for i, k := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[k]
+			if !ok {
+				return "", fmt.Errorf("no key %q at $.%s", k, strings.Join(path[:i], "."))
+			}
+			v = next
+		case []any:
+			n, err := strconv.Atoi(k)
+			if err != nil || n < 0 || n >= len(node) {
+				return "", fmt.Errorf("no index %s at $.%s", k, strings.Join(path[:i], "."))
+			}
+			v = node[n]
+		default:
+			return "", fmt.Errorf("cannot index %T with %q", v, k)
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case json.Number:
+		return val.String(), nil
+	case nil:
+		return "", errors.New("value is null")
+	default:
+		b, err := json.Marshal(val)
+		return string(b), err
+	}
+}
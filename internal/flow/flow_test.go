@@ -0,0 +1,152 @@
+package flow
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	def, err := Parse([]byte(`{"steps": [
+		{"name": "login", "method": "POST", "url": "/login", "extract": [{"var": "token", "from": "json", "expr": "$.token"}]},
+		{"url": "/items"}
+	]}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(def.Steps) != 2 {
+		t.Fatalf("got %d steps, want 2", len(def.Steps))
+	}
+	if def.Steps[0].Extract[0].Var != "token" {
+		t.Errorf("extract var = %q, want token", def.Steps[0].Extract[0].Var)
+	}
+	if def.Steps[1].Name != "step 2" {
+		t.Errorf("unnamed step got name %q, want %q", def.Steps[1].Name, "step 2")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, src := range map[string]string{
+		"not json":       `steps:`,
+		"no steps":       `{"steps": []}`,
+		"missing url":    `{"steps": [{"name": "a"}]}`,
+		"duplicate name": `{"steps": [{"name": "a", "url": "/"}, {"name": "a", "url": "/"}]}`,
+		"unknown field":  `{"steps": [{"url": "/", "headrs": {}}]}`,
+	} {
+		if _, err := Parse([]byte(src)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	resp := &http.Response{Header: http.Header{
+		"X-Request-Id": {"req-1"},
+		"Set-Cookie":   {"session=abc123; Path=/"},
+	}}
+	body := []byte(`{"token": "t0k", "items": [{"id": 17, "tags": ["a"]}, {"id": 18}], "none": null}`)
+
+	tests := []struct {
+		def     ExtractDef
+		want    string
+		wantErr bool
+	}{
+		{def: ExtractDef{Var: "v", From: "json", Expr: "$.token"}, want: "t0k"},
+		{def: ExtractDef{Var: "v", From: "json", Expr: "$.items[1].id"}, want: "18"},
+		{def: ExtractDef{Var: "v", From: "json", Expr: "items.0.tags"}, want: `["a"]`},
+		{def: ExtractDef{Var: "v", From: "json", Expr: "$.missing"}, wantErr: true},
+		{def: ExtractDef{Var: "v", From: "json", Expr: "$.items[5].id"}, wantErr: true},
+		{def: ExtractDef{Var: "v", From: "json", Expr: "$.none"}, wantErr: true},
+		{def: ExtractDef{Var: "v", From: "regex", Expr: `"id": (\d+)`}, want: "17"},
+		{def: ExtractDef{Var: "v", From: "regex", Expr: `t0k`}, want: "t0k"},
+		{def: ExtractDef{Var: "v", From: "regex", Expr: `nomatch`}, wantErr: true},
+		{def: ExtractDef{Var: "v", From: "header", Expr: "x-request-id"}, want: "req-1"},
+		{def: ExtractDef{Var: "v", From: "header", Expr: "X-Missing"}, wantErr: true},
+		{def: ExtractDef{Var: "v", From: "cookie", Expr: "session"}, want: "abc123"},
+		{def: ExtractDef{Var: "v", From: "cookie", Expr: "other"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		name := tt.def.From + " " + tt.def.Expr
+		t.Run(name, func(t *testing.T) {
+			e, err := NewExtractor(tt.def, 0)
+			if err != nil {
+				t.Fatalf("NewExtractor: %v", err)
+			}
+			got, err := e.Extract(resp, body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractFromArray(t *testing.T) {
+	body := []byte(`[{"id": 7}, {"id": 8, "name": "b"}]`)
+	tests := []struct {
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		{expr: "$[1].id", want: "8"},
+		{expr: "[1].name", want: "b"},
+		{expr: "$[0]", want: `{"id":7}`},
+		{expr: "$[2].id", wantErr: true},
+		{expr: "$.id", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := NewExtractor(ExtractDef{Var: "v", From: "json", Expr: tt.expr}, 0)
+			if err != nil {
+				t.Fatalf("NewExtractor: %v", err)
+			}
+			got, err := e.Extract(&http.Response{}, body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractNonJSONBody(t *testing.T) {
+	e, err := NewExtractor(ExtractDef{Var: "v", From: "json", Expr: "$.a"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Extract(&http.Response{}, []byte("<html>")); err == nil || !strings.Contains(err.Error(), "not JSON") {
+		t.Errorf("expected not-JSON error, got %v", err)
+	}
+}
+
+func TestNewExtractorErrors(t *testing.T) {
+	for _, def := range []ExtractDef{
+		{From: "json", Expr: "$.a"},
+		{Var: "v", From: "xml", Expr: "/a"},
+		{Var: "v", From: "json", Expr: "$"},
+		{Var: "v", From: "json", Expr: "$.a..b"},
+		{Var: "v", From: "regex", Expr: "("},
+		{Var: "v", From: "header"},
+	} {
+		if _, err := NewExtractor(def, 0); err == nil {
+			t.Errorf("NewExtractor(%+v) succeeded, want error", def)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package metrics keeps live counters of a running load test and exposes
+// them for scraping in the Prometheus text exposition format.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"goperf/internal/worker"
+)
+
+// Buckets are the upper bounds, in seconds, of the latency histogram.
+var Buckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Live holds the metrics of a running test. Observe is called by the
+// engine as results arrive; Snapshot may be called concurrently.
+type Live struct {
+	// InFlight counts requests currently being sent. Workers update it
+	// directly.
+	InFlight atomic.Int64
+
+	mu            sync.Mutex
+	statuses      map[int]uint64
+	errors        map[string]uint64
+	buckets       []uint64 // per bucket, not cumulative; the last is +Inf
+	sum           float64
+	count         uint64
+	activeWorkers int
+	targetRate    float64
+}
+
+// NewLive returns an empty Live for a run with the given number of
+// active workers and target rate (zero when unlimited).
+func NewLive(workers int, rate float64) *Live {
+	return &Live{
+		statuses:      make(map[int]uint64),
+		errors:        make(map[string]uint64),
+		buckets:       make([]uint64, len(Buckets)+1),
+		activeWorkers: workers,
+		targetRate:    rate,
+	}
+}
+
+// Observe records a request result. Transactions are ignored.
+func (l *Live) Observe(rr worker.Result) {
+	if rr.Transaction {
+		return
+	}
+	secs := rr.Duration.Seconds()
+	i, _ := slices.BinarySearch(Buckets, secs)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rr.Error != nil {
+		l.errors[ErrorClass(rr.Error)]++
+	} else {
+		l.statuses[rr.StatusCode]++
+	}
+	l.buckets[i]++
+	l.sum += secs
+	l.count++
+}
+
+// SetActiveWorkers updates the number of active workers.
+func (l *Live) SetActiveWorkers(n int) {
+	l.mu.Lock()
+	l.activeWorkers = n
+	l.mu.Unlock()
+}
+
+// Snapshot is a consistent copy of the metrics at one moment.
+type Snapshot struct {
+	Time     time.Time
+	Statuses map[int]uint64    // responses by status code
+	Errors   map[string]uint64 // transport errors by ErrorClass
+	// Buckets holds cumulative counts for each bound in the package
+	// Buckets, followed by the total count (+Inf).
+	Buckets       []uint64
+	Sum           float64 // seconds
+	Count         uint64
+	InFlight      int64
+	ActiveWorkers int
+	TargetRate    float64
+}
+
+// Snapshot copies the current metrics.
+func (l *Live) Snapshot() Snapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := Snapshot{
+		Time:          time.Now(),
+		Statuses:      maps.Clone(l.statuses),
+		Errors:        maps.Clone(l.errors),
+		Buckets:       make([]uint64, len(l.buckets)),
+		Sum:           l.sum,
+		Count:         l.count,
+		InFlight:      l.InFlight.Load(),
+		ActiveWorkers: l.activeWorkers,
+		TargetRate:    l.targetRate,
+	}
+	var cum uint64
+	for i, n := range l.buckets {
+		cum += n
+		s.Buckets[i] = cum
+	}
+	return s
+}
+
+// ErrorClass sorts a request error into a coarse class suitable as a
+// metric label: timeout, canceled, refused, reset, dns, tls or other.
+func ErrorClass(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "reset"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case strings.Contains(err.Error(), "tls:"), strings.Contains(err.Error(), "x509:"):
+		return "tls"
+	default:
+		return "other"
+	}
+}
+
+// WritePrometheus writes s in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer, s Snapshot) error {
+	var b strings.Builder
+
+	b.WriteString("# HELP goperf_requests_total Responses received, by status code.\n")
+	b.WriteString("# TYPE goperf_requests_total counter\n")
+	for _, code := range slices.Sorted(maps.Keys(s.Statuses)) {
+		fmt.Fprintf(&b, "goperf_requests_total{code=\"%d\"} %d\n", code, s.Statuses[code])
+	}
+
+	b.WriteString("# HELP goperf_request_errors_total Requests that failed without a response, by error class.\n")
+	b.WriteString("# TYPE goperf_request_errors_total counter\n")
+	for _, class := range slices.Sorted(maps.Keys(s.Errors)) {
+		fmt.Fprintf(&b, "goperf_request_errors_total{class=%q} %d\n", class, s.Errors[class])
+	}
+
+	b.WriteString("# HELP goperf_request_duration_seconds Request latency.\n")
+	b.WriteString("# TYPE goperf_request_duration_seconds histogram\n")
+	for i, le := range Buckets {
+		fmt.Fprintf(&b, "goperf_request_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(le, 'g', -1, 64), s.Buckets[i])
+	}
+	fmt.Fprintf(&b, "goperf_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", s.Count)
+	fmt.Fprintf(&b, "goperf_request_duration_seconds_sum %s\n", strconv.FormatFloat(s.Sum, 'g', -1, 64))
+	fmt.Fprintf(&b, "goperf_request_duration_seconds_count %d\n", s.Count)
+
+	b.WriteString("# HELP goperf_requests_in_flight Requests currently being sent.\n")
+	b.WriteString("# TYPE goperf_requests_in_flight gauge\n")
+	fmt.Fprintf(&b, "goperf_requests_in_flight %d\n", s.InFlight)
+
+	b.WriteString("# HELP goperf_active_workers Workers currently sending requests.\n")
+	b.WriteString("# TYPE goperf_active_workers gauge\n")
+	fmt.Fprintf(&b, "goperf_active_workers %d\n", s.ActiveWorkers)
+
+	b.WriteString("# HELP goperf_target_rate Target iterations per second, 0 when unlimited.\n")
+	b.WriteString("# TYPE goperf_target_rate gauge\n")
+	fmt.Fprintf(&b, "goperf_target_rate %s\n", strconv.FormatFloat(s.TargetRate, 'g', -1, 64))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Handler serves the metrics of l in the Prometheus text format.
+func Handler(l *Live) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w, l.Snapshot())
+	})
+}
+
+// Server serves /metrics for the duration of a run.
+type Server struct {
+	srv *http.Server
+	ln  net.Listener
+}
+
+// Serve starts serving the metrics of l at addr, such as ":9100". It
+// returns once the address is bound, so a port in use is reported before
+// the test starts.
+func Serve(addr string, l *Live) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(l))
+	s := &Server{srv: &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}, ln: ln}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Close stops the server, letting in-progress scrapes finish briefly.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
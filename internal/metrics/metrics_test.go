@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"goperf/internal/worker"
+)
+
+func TestObserveAndSnapshot(t *testing.T) {
+	l := NewLive(4, 100)
+	l.Observe(worker.Result{Duration: 500 * time.Microsecond, StatusCode: 200})
+	l.Observe(worker.Result{Duration: 3 * time.Millisecond, StatusCode: 200})
+	l.Observe(worker.Result{Duration: time.Millisecond, StatusCode: 503})
+	l.Observe(worker.Result{Duration: 20 * time.Second, Error: context.DeadlineExceeded})
+	l.Observe(worker.Result{Duration: time.Second, Transaction: true}) // ignored
+	l.InFlight.Add(2)
+	l.SetActiveWorkers(3)
+
+	s := l.Snapshot()
+	if s.Statuses[200] != 2 || s.Statuses[503] != 1 || s.Errors["timeout"] != 1 {
+		t.Errorf("statuses = %v, errors = %v", s.Statuses, s.Errors)
+	}
+	if s.Count != 4 || s.InFlight != 2 || s.ActiveWorkers != 3 || s.TargetRate != 100 {
+		t.Errorf("snapshot = %+v", s)
+	}
+	// Cumulative: <=1ms holds 0.5ms and 1ms, <=5ms adds 3ms, +Inf adds 20s.
+	want := map[float64]uint64{0.001: 2, 0.0025: 2, 0.005: 3, 10: 3}
+	for i, le := range Buckets {
+		if n, ok := want[le]; ok && s.Buckets[i] != n {
+			t.Errorf("bucket le=%g = %d, want %d", le, s.Buckets[i], n)
+		}
+	}
+	if last := s.Buckets[len(s.Buckets)-1]; last != 4 {
+		t.Errorf("+Inf bucket = %d, want 4", last)
+	}
+
+	// Snapshots are copies.
+	l.Observe(worker.Result{Duration: time.Millisecond, StatusCode: 200})
+	if s.Statuses[200] != 2 {
+		t.Error("snapshot changed after a later observation")
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{context.Canceled, "canceled"},
+		{fmt.Errorf("Get: %w", context.DeadlineExceeded), "timeout"},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, "refused"},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, "reset"},
+		{io.ErrUnexpectedEOF, "reset"},
+		{&net.DNSError{Err: "no such host", Name: "nope.invalid"}, "dns"},
+		{errors.New("tls: failed to verify certificate: x509: unknown authority"), "tls"},
+		{errors.New("something else"), "other"},
+	}
+	for _, tt := range tests {
+		if got := ErrorClass(tt.err); got != tt.want {
+			t.Errorf("ErrorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	l := NewLive(2, 0)
+	l.Observe(worker.Result{Duration: 2 * time.Millisecond, StatusCode: 200})
+	l.Observe(worker.Result{Duration: 2 * time.Millisecond, Error: context.Canceled})
+
+	srv, err := Serve("127.0.0.1:0", l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + srv.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	for _, want := range []string{
+		"# TYPE goperf_requests_total counter\n",
+		`goperf_requests_total{code="200"} 1` + "\n",
+		`goperf_request_errors_total{class="canceled"} 1` + "\n",
+		"# TYPE goperf_request_duration_seconds histogram\n",
+		`goperf_request_duration_seconds_bucket{le="0.001"} 0` + "\n",
+		`goperf_request_duration_seconds_bucket{le="0.0025"} 2` + "\n",
+		`goperf_request_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"goperf_request_duration_seconds_sum 0.004\n",
+		"goperf_request_duration_seconds_count 2\n",
+		"goperf_requests_in_flight 0\n",
+		"goperf_active_workers 2\n",
+		"goperf_target_rate 0\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	if _, err := Serve(srv.Addr().String(), l); err == nil {
+		t.Error("expected an error serving on an address in use")
+	}
+}
@@ -0,0 +1,165 @@
+// Package repeat runs the same load test several times and summarises how
+// much each metric varies between runs, so small differences can be told
+// apart from run-to-run noise.
+package repeat
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+	"goperf/internal/report"
+)
+
+// RunFunc executes one run.
+type RunFunc func(config.Config) (engine.Result, error)
+
+// Run executes cfg.Repeat runs of cfg, pausing for cfg.Cooldown between
+// them. onRun, if non-nil, is called as each run completes with its
+// 1-based number.
+func Run(cfg config.Config, run RunFunc, onRun func(int, engine.Result)) ([]engine.Result, error) {
+	results := make([]engine.Result, 0, cfg.Repeat)
+	for i := range cfg.Repeat {
+		if i > 0 {
+			time.Sleep(cfg.Cooldown)
+		}
+		res, err := run(cfg)
+		if err != nil {
+			return results, fmt.Errorf("run %d: %w", i+1, err)
+		}
+		results = append(results, res)
+		if onRun != nil {
+			onRun(i+1, res)
+		}
+	}
+	return results, nil
+}
+
+// Metric summarises one metric across runs. Latencies are in nanoseconds
+// and the error rate in percent.
+type Metric struct {
+	Name   string
+	Values []float64 // one per run
+
+	Mean   float64
+	StdDev float64 // sample standard deviation
+	// CILow and CIHigh bound the 95% confidence interval of the mean,
+	// from Student's t distribution.
+	CILow, CIHigh float64
+}
+
+// Summarize computes RPS, P50, P99 and error rate statistics across runs.
+// The latencies of each run are those its report headlines.
+func Summarize(results []engine.Result) []Metric {
+	metrics := []Metric{{Name: "RPS"}, {Name: "P50"}, {Name: "P99"}, {Name: "Errors"}}
+	for _, res := range results {
+		h := report.ComputeHeadline(res)
+		for i, v := range []float64{h.RPS, float64(h.P50), float64(h.P99), h.ErrorRate} {
+			metrics[i].Values = append(metrics[i].Values, v)
+		}
+	}
+	for i := range metrics {
+		metrics[i].summarize()
+	}
+	return metrics
+}
+
+func (m *Metric) summarize() {
+	n := float64(len(m.Values))
+	if n == 0 {
+		return
+	}
+	for _, v := range m.Values {
+		m.Mean += v
+	}
+	m.Mean /= n
+	m.CILow, m.CIHigh = m.Mean, m.Mean
+	if n < 2 {
+		return
+	}
+	var ss float64
+	for _, v := range m.Values {
+		ss += (v - m.Mean) * (v - m.Mean)
+	}
+	m.StdDev = math.Sqrt(ss / (n - 1))
+	half := tCritical(len(m.Values)-1) * m.StdDev / math.Sqrt(n)
+	// Every metric is non-negative, so the interval is too.
+	m.CILow, m.CIHigh = max(0, m.Mean-half), m.Mean+half
+}
+
+// t95 holds two-sided 95% critical values of Student's t distribution for
+// 1 to 30 degrees of freedom.
+var t95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func tCritical(df int) float64 {
+	switch {
+	case df <= len(t95):
+		return t95[df-1]
+	case df <= 60:
+		return 2.000
+	case df <= 120:
+		return 1.980
+	default:
+		return 1.960
+	}
+}
+
+// Print writes each run's numbers and the statistics across runs.
+func Print(w io.Writer, cfg config.Config, metrics []Metric) error {
+	runs := 0
+	if len(metrics) > 0 {
+		runs = len(metrics[0].Values)
+	}
+	_, err := fmt.Fprintf(w, `
+--- goperf results: %d runs ---
+Target:       %s %s
+Duration:     %s per run, %s cool-down
+Concurrency:  %d
+
+%4s  %12s  %12s  %12s  %8s
+`, runs, cfg.Method, cfg.URL, cfg.Duration, cfg.Cooldown, cfg.Concurrency,
+		"Run", "RPS", "P50", "P99", "Errors")
+	if err != nil {
+		return err
+	}
+
+	for i := range runs {
+		fmt.Fprintf(w, "%4d", i+1)
+		for _, m := range metrics {
+			width := 12
+			if m.Name == "Errors" {
+				width = 8
+			}
+			fmt.Fprintf(w, "  %*s", width, format(m.Name, m.Values[i]))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "%-8s  %12s  %12s  %s\n", "Metric", "Mean", "StdDev", "95% CI")
+	for _, m := range metrics {
+		fmt.Fprintf(w, "%-8s  %12s  %12s  %s..%s\n", m.Name,
+			format(m.Name, m.Mean), format(m.Name, m.StdDev),
+			format(m.Name, m.CILow), format(m.Name, m.CIHigh))
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+func format(metric string, v float64) string {
+	switch metric {
+	case "RPS":
+		return fmt.Sprintf("%.2f", v)
+	case "Errors":
+		return fmt.Sprintf("%.2f%%", v)
+	default:
+		return time.Duration(v).Round(time.Microsecond).String()
+	}
+}
@@ -0,0 +1,111 @@
+package repeat
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+)
+
+// fakeResult returns a run of 100 requests over a second, all taking lat,
+// with failed of them failing.
+func fakeResult(lat time.Duration, failed int) engine.Result {
+	lats := make([]time.Duration, 100)
+	for i := range lats {
+		lats[i] = lat
+	}
+	return engine.Result{TotalRequests: 100, Succeeded: 100 - failed, Failed: failed, Latencies: lats, TotalDuration: time.Second}
+}
+
+func TestRun(t *testing.T) {
+	cfg := config.Config{Repeat: 3, Cooldown: 10 * time.Millisecond}
+	var calls, reported int
+	start := time.Now()
+	results, err := Run(cfg, func(config.Config) (engine.Result, error) {
+		calls++
+		return fakeResult(time.Millisecond, 0), nil
+	}, func(i int, _ engine.Result) {
+		reported++
+		if i != reported {
+			t.Errorf("run reported as %d, want %d", i, reported)
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 || len(results) != 3 || reported != 3 {
+		t.Errorf("calls = %d, results = %d, reported = %d, want 3 each", calls, len(results), reported)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("took %s, want two 10ms cool-downs", elapsed)
+	}
+
+	boom := errors.New("boom")
+	_, err = Run(cfg, func(config.Config) (engine.Result, error) { return engine.Result{}, boom }, nil)
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), "run 1") {
+		t.Errorf("err = %v, want run 1: boom", err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := []engine.Result{
+		fakeResult(10*time.Millisecond, 0),
+		fakeResult(12*time.Millisecond, 2),
+		fakeResult(14*time.Millisecond, 4),
+	}
+	metrics := Summarize(results)
+
+	byName := make(map[string]Metric)
+	for _, m := range metrics {
+		byName[m.Name] = m
+	}
+	p50 := byName["P50"]
+	if p50.Mean != float64(12*time.Millisecond) {
+		t.Errorf("P50 mean = %s, want 12ms", time.Duration(p50.Mean))
+	}
+	if p50.StdDev != float64(2*time.Millisecond) {
+		t.Errorf("P50 stddev = %s, want 2ms", time.Duration(p50.StdDev))
+	}
+	// t(2) = 4.303: 12ms ± 4.303 * 2ms / sqrt(3).
+	half := 4.303 * float64(2*time.Millisecond) / math.Sqrt(3)
+	if math.Abs(p50.CIHigh-(p50.Mean+half)) > 1 || math.Abs(p50.CILow-(p50.Mean-half)) > 1 {
+		t.Errorf("P50 CI = %s..%s, want 12ms ± %s", time.Duration(p50.CILow), time.Duration(p50.CIHigh), time.Duration(half))
+	}
+	if errs := byName["Errors"]; errs.Mean != 2 || errs.CILow != 0 {
+		t.Errorf("Errors mean = %g, CI low = %g; want 2 and clamped to 0", errs.Mean, errs.CILow)
+	}
+	if rps := byName["RPS"]; rps.Mean != 100 || rps.StdDev != 0 {
+		t.Errorf("RPS mean = %g, stddev = %g; want 100 and 0", rps.Mean, rps.StdDev)
+	}
+
+	single := Summarize(results[:1])[1]
+	if single.StdDev != 0 || single.CILow != single.Mean || single.CIHigh != single.Mean {
+		t.Errorf("single run: %+v, want a zero-width interval", single)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := config.Config{Method: "GET", URL: "http://example.com", Duration: 10 * time.Second, Concurrency: 4, Cooldown: 5 * time.Second}
+	metrics := Summarize([]engine.Result{fakeResult(10*time.Millisecond, 0), fakeResult(12*time.Millisecond, 1)})
+
+	var buf bytes.Buffer
+	if err := Print(&buf, cfg, metrics); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"--- goperf results: 2 runs ---",
+		"Duration:     10s per run, 5s cool-down",
+		"   2        100.00          12ms          12ms     1.00%",
+		"P50               11ms       1.414ms  0s..23.706ms",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
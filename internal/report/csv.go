@@ -0,0 +1,116 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+)
+
+// csvHeader names the columns of the CSV summary. The columns never
+// depend on the run, so the rows of many runs can be appended to one
+// file under a single header.
+var csvHeader = []string{
+	"started", "method", "url", "concurrency", "duration_seconds",
+	"requests", "succeeded", "failed", "error_rate", "rps",
+	"latency_fastest_ms", "latency_average_ms", "latency_p50_ms",
+	"latency_p90_ms", "latency_p99_ms", "latency_slowest_ms",
+}
+
+// PrintCSV writes the summary statistics of a run as a header and one
+// row, or the row alone with cfg.CSVNoHeader. Latencies are in
+// milliseconds and, like the text report, those of successful requests
+// once any fail; error_rate is a fraction. When set, cfg.CSVTimeline
+// and cfg.CSVStatus name files that receive the timeline and the count
+// of each status code.
+func PrintCSV(w io.Writer, cfg config.Config, res engine.Result) error {
+	if cfg.CSVTimeline != "" {
+		if err := writeCSVFile(cfg.CSVTimeline, timelineRecords(res)); err != nil {
+			return fmt.Errorf("timeline: %w", err)
+		}
+	}
+	if cfg.CSVStatus != "" {
+		if err := writeCSVFile(cfg.CSVStatus, statusRecords(res)); err != nil {
+			return fmt.Errorf("status counts: %w", err)
+		}
+	}
+
+	h := ComputeHeadline(res)
+	var started string
+	if !res.Started.IsZero() {
+		started = res.Started.UTC().Format(time.RFC3339Nano)
+	}
+	row := []string{
+		started, cfg.Method, cfg.URL, strconv.Itoa(cfg.Concurrency), csvFloat(res.TotalDuration.Seconds()),
+		strconv.Itoa(res.TotalRequests), strconv.Itoa(res.Succeeded), strconv.Itoa(res.Failed), csvFloat(h.ErrorRate / 100), csvFloat(h.RPS),
+		csvMS(h.Fastest), csvMS(h.Average), csvMS(h.P50),
+		csvMS(h.P90), csvMS(h.P99), csvMS(h.Slowest),
+	}
+	if cfg.CSVNoHeader {
+		return writeCSV(w, [][]string{row})
+	}
+	return writeCSV(w, [][]string{csvHeader, row})
+}
+
+// timelineRecords lists each timeline interval with its throughput.
+// Elapsed is the end of the interval, so the last row may cover less
+// than engine.TimelineInterval.
+func timelineRecords(res engine.Result) [][]string {
+	records := [][]string{{"elapsed_seconds", "requests", "failed", "rps", "p50_ms", "p90_ms", "p99_ms"}}
+	var prev time.Duration
+	for _, iv := range res.Timeline {
+		var rps float64
+		if d := iv.Elapsed - prev; d > 0 {
+			rps = float64(iv.Requests) / d.Seconds()
+		}
+		prev = iv.Elapsed
+		records = append(records, []string{
+			csvFloat(iv.Elapsed.Seconds()), strconv.Itoa(iv.Requests), strconv.Itoa(iv.Failed), csvFloat(rps),
+			csvMS(iv.P50), csvMS(iv.P90), csvMS(iv.P99),
+		})
+	}
+	return records
+}
+
+// statusRecords lists the count of each status code in numeric order.
+func statusRecords(res engine.Result) [][]string {
+	records := [][]string{{"status", "count"}}
+	for _, code := range slices.Sorted(maps.Keys(res.StatusCodes)) {
+		records = append(records, []string{strconv.Itoa(code), strconv.Itoa(res.StatusCodes[code])})
+	}
+	return records
+}
+
+func writeCSVFile(path string, records [][]string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return writeCSV(f, records)
+}
+
+func writeCSV(w io.Writer, records [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.WriteAll(records)
+	return cw.Error()
+}
+
+func csvFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func csvMS(d time.Duration) string {
+	return csvFloat(ms(d))
+}
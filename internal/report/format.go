@@ -0,0 +1,108 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+)
+
+// Formatter writes a report of a run in one output format.
+type Formatter func(w io.Writer, cfg config.Config, res engine.Result) error
+
+var formats = map[string]Formatter{
+	"text":     Print,
+	"json":     PrintJSON,
+	"csv":      PrintCSV,
+	"junit":    PrintJUnit,
+	"markdown": PrintMarkdown,
+}
+
+// Formats returns the names of the available report formats, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Lookup returns the formatter for the named format.
+func Lookup(name string) (Formatter, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q, want one of %s", name, strings.Join(Formats(), ", "))
+	}
+	return f, nil
+}
+
+// Summary is the machine-readable form of a report. Latencies are in
+// milliseconds and, like the other formats, those of successful
+// requests once any fail, as SuccessOnly records.
+type Summary struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	Concurrency int            `json:"concurrency"`
+	Duration    float64        `json:"duration_seconds"`
+	Requests    int            `json:"requests"`
+	Succeeded   int            `json:"succeeded"`
+	Failed      int            `json:"failed"`
+	RPS         float64        `json:"rps"`
+	Latency     LatencySummary `json:"latency_ms"`
+	SuccessOnly bool           `json:"latency_success_only"`
+	StatusCodes map[int]int    `json:"status_codes"`
+	Errors      map[string]int `json:"errors"`
+}
+
+// LatencySummary holds latency statistics in milliseconds.
+type LatencySummary struct {
+	Fastest float64 `json:"fastest"`
+	Average float64 `json:"average"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P99     float64 `json:"p99"`
+	Slowest float64 `json:"slowest"`
+}
+
+// Summarize builds the Summary of a run.
+func Summarize(cfg config.Config, res engine.Result) Summary {
+	h := ComputeHeadline(res)
+	return Summary{
+		Method:      cfg.Method,
+		URL:         cfg.URL,
+		Concurrency: cfg.Concurrency,
+		Duration:    res.TotalDuration.Seconds(),
+		Requests:    res.TotalRequests,
+		Succeeded:   res.Succeeded,
+		Failed:      res.Failed,
+		RPS:         h.RPS,
+		Latency: LatencySummary{
+			Fastest: ms(h.Fastest),
+			Average: ms(h.Average),
+			P50:     ms(h.P50),
+			P90:     ms(h.P90),
+			P99:     ms(h.P99),
+			Slowest: ms(h.Slowest),
+		},
+		SuccessOnly: h.SuccessOnly,
+		StatusCodes: res.StatusCodes,
+		Errors:      res.Errors,
+	}
+}
+
+// PrintJSON writes the Summary of a run as indented JSON.
+func PrintJSON(w io.Writer, cfg config.Config, res engine.Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Summarize(cfg, res))
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
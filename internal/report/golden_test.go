@@ -0,0 +1,107 @@
+package report
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestPrintGolden compares the text report with the files in testdata.
+// Run with -update to rewrite them after an intended change.
+func TestPrintGolden(t *testing.T) {
+	cfg := config.Config{Method: "GET", URL: "http://example.com/api", Concurrency: 4}
+
+	success := engine.Result{
+		TotalRequests: 100,
+		Succeeded:     100,
+		StatusCodes:   map[int]int{200: 90, 204: 8, 304: 2},
+		TotalDuration: 10 * time.Second,
+	}
+	for i := range 100 {
+		code := uint16(200)
+		switch {
+		case i >= 98:
+			code = 304
+		case i >= 90:
+			code = 204
+		}
+		success.Latencies = append(success.Latencies, time.Duration(i+1)*time.Millisecond)
+		success.Statuses = append(success.Statuses, code)
+	}
+
+	// Several codes per class and errors tied on count, so any map
+	// iteration order leaking into the output shows up as a diff.
+	failures := engine.Result{
+		TotalRequests: 42,
+		Succeeded:     28,
+		Failed:        14,
+		StatusCodes:   map[int]int{503: 2, 200: 20, 404: 3, 201: 5, 500: 1, 301: 3},
+		Errors: map[string]int{
+			"dial tcp: connection refused": 2,
+			"context deadline exceeded":    2,
+			"EOF":                          4,
+		},
+		TotalDuration: 4 * time.Second,
+	}
+	for _, group := range []struct {
+		code      uint16
+		latencies []time.Duration
+	}{
+		{200, durations(10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110, 120, 130, 140, 150, 160, 170, 180, 190, 200)},
+		{201, durations(25, 35, 45, 55, 65)},
+		{301, durations(5, 6, 7)},
+		{404, durations(2, 3, 4)},
+		{500, durations(900)},
+		{503, durations(1, 1)},
+		{0, durations(1000, 1000, 3, 3, 4, 4, 5, 5)},
+	} {
+		for _, d := range group.latencies {
+			failures.Latencies = append(failures.Latencies, d)
+			failures.Statuses = append(failures.Statuses, group.code)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		res  engine.Result
+	}{
+		{"success", success},
+		{"failures", failures},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Print(&buf, cfg, tt.res); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != string(want) {
+				t.Errorf("report differs from %s (run go test -update to accept):\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func durations(ms ...int) []time.Duration {
+	ds := make([]time.Duration, len(ms))
+	for i, n := range ms {
+		ds[i] = time.Duration(n) * time.Millisecond
+	}
+	return ds
+}
@@ -0,0 +1,74 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// histogramWidth is the length of the longest histogram bar.
+const histogramWidth = 40
+
+// printHistogram writes an ASCII histogram of sorted latencies, with a
+// row per bucket up to each bound and a last row for anything slower.
+// Without bounds, buckets are chosen to span the latencies.
+func printHistogram(w io.Writer, bounds []time.Duration, sorted []time.Duration) {
+	if len(bounds) == 0 {
+		bounds = autoBuckets(sorted[0], sorted[len(sorted)-1])
+	}
+
+	labels := make([]string, 0, len(bounds)+1)
+	counts := make([]int, 0, len(bounds)+1)
+	below := 0
+	for _, b := range bounds {
+		n := sort.Search(len(sorted), func(i int) bool { return sorted[i] > b })
+		labels = append(labels, "<= "+b.String())
+		counts = append(counts, n-below)
+		below = n
+	}
+	if over := len(sorted) - below; over > 0 {
+		labels = append(labels, "> "+bounds[len(bounds)-1].String())
+		counts = append(counts, over)
+	}
+
+	var width, most int
+	for i := range labels {
+		width = max(width, len(labels[i]))
+		most = max(most, counts[i])
+	}
+
+	fmt.Fprintf(w, "Latency histogram:\n")
+	for i, n := range counts {
+		bar := n * histogramWidth / most
+		if n > 0 {
+			// Keep small modes visible.
+			bar = max(bar, 1)
+		}
+		line := fmt.Sprintf("  %-*s  %8d  %5.1f%%  %s",
+			width, labels[i], n, 100*float64(n)/float64(len(sorted)), strings.Repeat("#", bar))
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	fmt.Fprintln(w)
+}
+
+// autoBuckets returns the bounds of the 1-2-5 series from the first at
+// or above fastest to the first at or above slowest, ending at slowest
+// beyond the series.
+func autoBuckets(fastest, slowest time.Duration) []time.Duration {
+	var bounds []time.Duration
+	for m := 100 * time.Microsecond; m <= time.Hour; m *= 10 {
+		for _, f := range []time.Duration{1, 2, 5} {
+			b := m * f
+			if b < fastest {
+				continue
+			}
+			bounds = append(bounds, b)
+			if b >= slowest {
+				return bounds
+			}
+		}
+	}
+	return append(bounds, slowest)
+}
@@ -0,0 +1,112 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+)
+
+// JUnit XML, as read by CI systems: a suite for the run with a test case
+// per threshold and the summary statistics as properties.
+type (
+	junitSuites struct {
+		XMLName xml.Name     `xml:"testsuites"`
+		Suites  []junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name       string          `xml:"name,attr"`
+		Tests      int             `xml:"tests,attr"`
+		Failures   int             `xml:"failures,attr"`
+		Time       string          `xml:"time,attr"`
+		Properties []junitProperty `xml:"properties>property"`
+		Cases      []junitCase     `xml:"testcase"`
+	}
+	junitProperty struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	}
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		Classname string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+	}
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+// PrintJUnit writes a run as JUnit XML, with each threshold a test case
+// that fails with the measured value when it is not met.
+func PrintJUnit(w io.Writer, cfg config.Config, res engine.Result) error {
+	checks := Checks(cfg, res)
+	suite := junitSuite{
+		Name:       "goperf " + cfg.Method + " " + cfg.URL,
+		Tests:      len(checks),
+		Failures:   len(Failed(checks)),
+		Time:       seconds(res.TotalDuration),
+		Properties: junitProperties(cfg, res),
+	}
+	for _, c := range checks {
+		tc := junitCase{Name: c.Threshold.String(), Classname: "goperf.thresholds", Time: seconds(res.TotalDuration)}
+		if !c.Pass {
+			tc.Failure = &junitFailure{Message: c.Message(), Type: "threshold", Text: c.Message()}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// junitProperties lists the summary statistics of a run. Latencies are
+// in milliseconds and, like the text report, those of successful
+// requests once any fail.
+func junitProperties(cfg config.Config, res engine.Result) []junitProperty {
+	h := ComputeHeadline(res)
+
+	props := []junitProperty{
+		{"requests", strconv.Itoa(res.TotalRequests)},
+		{"succeeded", strconv.Itoa(res.Succeeded)},
+		{"failed", strconv.Itoa(res.Failed)},
+		{"duration_seconds", seconds(res.TotalDuration)},
+		{"rps", strconv.FormatFloat(h.RPS, 'f', 2, 64)},
+		{"concurrency", strconv.Itoa(cfg.Concurrency)},
+		{"latency_ms.fastest", msString(h.Fastest)},
+		{"latency_ms.average", msString(h.Average)},
+	}
+	for _, p := range percentiles(cfg, h.latencies) {
+		props = append(props, junitProperty{"latency_ms." + strings.ToLower(p.name), msString(p.d)})
+	}
+	props = append(props, junitProperty{"latency_ms.slowest", msString(h.Slowest)})
+	for _, code := range slices.Sorted(maps.Keys(res.StatusCodes)) {
+		props = append(props, junitProperty{fmt.Sprintf("status.%d", code), strconv.Itoa(res.StatusCodes[code])})
+	}
+	return props
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func msString(d time.Duration) string {
+	return strconv.FormatFloat(ms(d), 'f', 3, 64)
+}
@@ -0,0 +1,212 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+	"goperf/internal/runfile"
+)
+
+// PrintMarkdown writes a compact GitHub-flavoured Markdown summary of a
+// run, suitable for a pull request comment. When cfg.Baseline names a
+// saved run, each table gains the baseline's values and the change from
+// them.
+func PrintMarkdown(w io.Writer, cfg config.Config, res engine.Result) error {
+	var base *engine.Result
+	if cfg.Baseline != "" {
+		run, err := runfile.Load(cfg.Baseline)
+		if err != nil {
+			return fmt.Errorf("baseline: %w", err)
+		}
+		base = &run.Result
+	}
+
+	h, baseH := ComputeHeadline(res), Headline{}
+	if base != nil {
+		baseH = ComputeHeadline(*base)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "### goperf: `%s %s`\n\n", cfg.Method, cfg.URL)
+	fmt.Fprintf(&b, "%d requests in %s with concurrency %d, %d failed (%.2f%%)",
+		res.TotalRequests, res.TotalDuration.Round(time.Millisecond), cfg.Concurrency, res.Failed, h.ErrorRate)
+	if base != nil {
+		fmt.Fprintf(&b, ", compared with `%s`", cfg.Baseline)
+	}
+	b.WriteString(".\n\n")
+
+	// Latency.
+	t := newMDTable(base != nil, "Latency", "Value")
+	lat, baseLat := markdownLatency(cfg, h), markdownLatency(cfg, baseH)
+	if h.SuccessOnly {
+		t.title = "Latency (successes)"
+	}
+	for i, v := range lat {
+		var bv *mdValue
+		if base != nil {
+			bv = &baseLat[i]
+		}
+		t.row(v.name, v, bv)
+	}
+	t.write(&b)
+
+	// Throughput.
+	t = newMDTable(base != nil, "Throughput", "Value")
+	for i, v := range markdownThroughput(res, h) {
+		var bv *mdValue
+		if base != nil {
+			bv = &markdownThroughput(*base, baseH)[i]
+		}
+		t.row(v.name, v, bv)
+	}
+	t.write(&b)
+
+	// Status codes and errors, with baseline counts. Those seen in only
+	// one of the runs are listed with a count of zero in the other.
+	statuses, errs := maps.Clone(res.StatusCodes), maps.Clone(res.Errors)
+	if base != nil {
+		statuses, errs = union(statuses, base.StatusCodes), union(errs, base.Errors)
+	}
+	if len(statuses) > 0 {
+		t = newMDTable(base != nil, "Status", "Count")
+		for _, code := range slices.Sorted(maps.Keys(statuses)) {
+			var bv *mdValue
+			if base != nil {
+				bv = count(base.StatusCodes[code])
+			}
+			t.row(fmt.Sprint(code), *count(res.StatusCodes[code]), bv)
+		}
+		t.write(&b)
+	}
+	if len(errs) > 0 {
+		t = newMDTable(base != nil, "Error", "Count")
+		for _, msg := range sortedErrors(errs) {
+			var bv *mdValue
+			if base != nil {
+				bv = count(base.Errors[msg])
+			}
+			t.row(msg, *count(res.Errors[msg]), bv)
+		}
+		t.write(&b)
+	}
+
+	if checks := Checks(cfg, res); len(checks) > 0 {
+		b.WriteString("| Threshold | Result |\n|---|---|\n")
+		for _, c := range checks {
+			verdict := "✅ pass"
+			if !c.Pass {
+				verdict = "❌ " + mdEscape(c.Message())
+			}
+			fmt.Fprintf(&b, "| `%s` | %s |\n", c.Threshold, verdict)
+		}
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mdValue is a table cell holding a number, formatted for display.
+type mdValue struct {
+	name string
+	v    float64
+	text string
+}
+
+func count(n int) *mdValue {
+	return &mdValue{v: float64(n), text: fmt.Sprint(n)}
+}
+
+// markdownLatency returns the latency rows of a run's headline.
+func markdownLatency(cfg config.Config, h Headline) []mdValue {
+	dur := func(name string, d time.Duration) mdValue {
+		return mdValue{name, d.Seconds(), d.Round(time.Microsecond).String()}
+	}
+	rows := []mdValue{dur("Average", h.Average)}
+	for _, p := range percentiles(cfg, h.latencies) {
+		rows = append(rows, dur(p.name, p.d))
+	}
+	return append(rows, dur("Max", h.Slowest))
+}
+
+func markdownThroughput(res engine.Result, h Headline) []mdValue {
+	return []mdValue{
+		{"Requests/s", h.RPS, fmt.Sprintf("%.2f", h.RPS)},
+		{"Requests", float64(res.TotalRequests), fmt.Sprint(res.TotalRequests)},
+		{"Error rate", h.ErrorRate, fmt.Sprintf("%.2f%%", h.ErrorRate)},
+	}
+}
+
+// mdTable accumulates a Markdown table with an optional baseline and
+// change column.
+type mdTable struct {
+	title, value string
+	baseline     bool
+	rows         []string
+}
+
+func newMDTable(baseline bool, title, value string) *mdTable {
+	return &mdTable{title: title, value: value, baseline: baseline}
+}
+
+func (t *mdTable) row(name string, v mdValue, base *mdValue) {
+	r := fmt.Sprintf("| %s | %s |", mdEscape(name), v.text)
+	if t.baseline {
+		r += fmt.Sprintf(" %s | %s |", base.text, delta(v.v, base.v))
+	}
+	t.rows = append(t.rows, r)
+}
+
+func (t *mdTable) write(b *strings.Builder) {
+	if t.baseline {
+		fmt.Fprintf(b, "| %s | %s | Baseline | Change |\n|---|---:|---:|---:|\n", t.title, t.value)
+	} else {
+		fmt.Fprintf(b, "| %s | %s |\n|---|---:|\n", t.title, t.value)
+	}
+	for _, r := range t.rows {
+		b.WriteString(r + "\n")
+	}
+	b.WriteString("\n")
+}
+
+// delta formats the relative change from base to v with an up or down
+// indicator.
+func delta(v, base float64) string {
+	switch {
+	case v == base:
+		return "="
+	case base == 0:
+		return "▲ new"
+	}
+	change := 100 * (v - base) / base
+	arrow := "▲"
+	if change < 0 {
+		arrow = "▼"
+	}
+	return fmt.Sprintf("%s %+.1f%%", arrow, change)
+}
+
+// mdEscape keeps text from breaking out of a table cell.
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+// union adds the keys of other missing from m with a zero count, so
+// iterating m covers both, ordered by the counts of m.
+func union[K comparable](m, other map[K]int) map[K]int {
+	if m == nil {
+		m = make(map[K]int)
+	}
+	for k := range other {
+		if _, ok := m[k]; !ok {
+			m[k] = 0
+		}
+	}
+	return m
+}
@@ -2,14 +2,20 @@
 package report
 
 import (
+	"cmp"
 	"fmt"
 	"io"
+	"maps"
 	"math"
-	"sort"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"goperf/internal/config"
 	"goperf/internal/engine"
+	"goperf/internal/sink"
 )
 
 // Stats holds computed latency percentiles and throughput for a load test.
@@ -24,15 +30,34 @@ type Stats struct {
 }
 
 // Compute calculates latency percentiles, average, and requests per second
-// from the raw engine result.
+// from the raw engine result, over every request including failures.
+// The report formats headline ComputeHeadline instead.
 func Compute(res engine.Result) Stats {
 	if len(res.Latencies) == 0 {
 		return Stats{}
 	}
+	stats := ComputeLatencies(res.Latencies)
+	stats.RPS = float64(res.TotalRequests) / res.TotalDuration.Seconds()
+	return stats
+}
+
+// ComputeLatencies calculates the latency fields of Stats for a set of
+// samples, leaving RPS zero.
+func ComputeLatencies(latencies []time.Duration) Stats {
+	return computeSorted(sortLatencies(latencies))
+}
+
+// sortLatencies returns a sorted copy of latencies.
+func sortLatencies(latencies []time.Duration) []time.Duration {
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	return sorted
+}
 
-	sorted := make([]time.Duration, len(res.Latencies))
-	copy(sorted, res.Latencies)
-	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+func computeSorted(sorted []time.Duration) Stats {
+	if len(sorted) == 0 {
+		return Stats{}
+	}
 
 	var total time.Duration
 	for _, d := range sorted {
@@ -42,19 +67,25 @@ func Compute(res engine.Result) Stats {
 	n := len(sorted)
 	return Stats{
 		Average: total / time.Duration(n),
-		P50:     sorted[percentileIndex(n, 50)],
-		P90:     sorted[percentileIndex(n, 90)],
-		P99:     sorted[percentileIndex(n, 99)],
-		RPS:     float64(res.TotalRequests) / res.TotalDuration.Seconds(),
+		P50:     Percentile(sorted, 50),
+		P90:     Percentile(sorted, 90),
+		P99:     Percentile(sorted, 99),
 		Fastest: sorted[0],
 		Slowest: sorted[n-1],
 	}
 }
 
+// Percentile returns the p-th percentile of sorted, which must not be
+// empty, using the nearest-rank method.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[percentileIndex(len(sorted), p)]
+}
+
 // percentileIndex returns the index for the given percentile using the
 // nearest-rank method: index = ceil(p/100 * n) - 1.
-func percentileIndex(n, p int) int {
-	idx := int(math.Ceil(float64(p)/100*float64(n))) - 1
+func percentileIndex(n int, p float64) int {
+	// The epsilon keeps float error in p*n/100 from skipping a rank.
+	idx := int(math.Ceil(p*float64(n)/100-1e-9)) - 1
 	if idx < 0 {
 		return 0
 	}
@@ -66,57 +97,426 @@ func percentileIndex(n, p int) int {
 
 // Print writes a formatted load test report to the given writer.
 func Print(w io.Writer, cfg config.Config, res engine.Result) error {
-	stats := Compute(res)
+	// Once requests fail, the headline latency is that of successes
+	// alone and the breakdown table covers the rest.
+	h := ComputeHeadline(res)
+	heading := "Latency:"
+	if h.SuccessOnly {
+		heading = "Latency:      successful requests only, see the breakdown below"
+	}
+	stats := h.Stats
+	pcts := percentiles(cfg, h.latencies)
+	var latency strings.Builder
+	for _, p := range pcts {
+		fmt.Fprintf(&latency, "  %-12s%s\n", p.name+":", p.d.Round(time.Microsecond))
+	}
+
+	// Optional header lines for settings that are off by default.
+	var setup strings.Builder
+	if cfg.Rate > 0 {
+		fmt.Fprintf(&setup, "Rate:         %g/s, %s arrivals (seed %d)\n", cfg.Rate, cfg.Arrival, res.Seed)
+	}
+	if cfg.AdaptiveP95 > 0 {
+		fmt.Fprintf(&setup, "Adaptive:     hold P95 at %s with 1-%d workers, adjusted every %s\n",
+			cfg.AdaptiveP95, cfg.Concurrency, cfg.AdaptInterval)
+	}
+	if cfg.MetricsAddr != "" {
+		fmt.Fprintf(&setup, "Metrics:      served on %s/metrics during the run\n", cfg.MetricsAddr)
+	}
+	if len(cfg.Sinks) > 0 {
+		sinks := make([]string, len(cfg.Sinks))
+		for i, raw := range cfg.Sinks {
+			sinks[i] = sink.Redact(raw)
+		}
+		fmt.Fprintf(&setup, "Sinks:        %s every %s\n", strings.Join(sinks, ", "), cfg.SinkInterval)
+	}
+	if cfg.Trace || cfg.OTLPEndpoint != "" {
+		fmt.Fprintf(&setup, "Tracing:      traceparent sent on every request\n")
+	}
+	if cfg.Proxy != "" {
+		fmt.Fprintf(&setup, "Proxy:        %s\n", redactProxy(cfg.Proxy))
+	}
+	if cfg.Flow != "" {
+		fmt.Fprintf(&setup, "Flow:         %s\n", cfg.Flow)
+	}
+	if cfg.Cookies {
+		reset := "kept for the whole run"
+		if cfg.SessionReset > 0 {
+			reset = fmt.Sprintf("reset every %d iterations", cfg.SessionReset)
+		}
+		fmt.Fprintf(&setup, "Cookies:      per worker, %s\n", reset)
+	}
+	if cfg.Data != "" {
+		exhausted := "recycled"
+		if cfg.DataStop {
+			exhausted = "stop when exhausted"
+		}
+		fmt.Fprintf(&setup, "Data:         %s (%s, %s)\n", cfg.Data, cfg.DataMode, exhausted)
+	}
 
 	_, err := fmt.Fprintf(w, `
 --- goperf results ---
 Target:       %s %s
-Duration:     %s
+%sDuration:     %s
 Concurrency:  %d
 
 Requests:     %d total, %d succeeded, %d failed
 
-Latency:
+%s
   Fastest:    %s
   Slowest:    %s
   Average:    %s
-  P50:        %s
-  P90:        %s
-  P99:        %s
-
+%s
 Throughput:   %.2f req/s
 `,
 		cfg.Method, cfg.URL,
+		setup.String(),
 		res.TotalDuration.Round(time.Millisecond),
 		cfg.Concurrency,
 		res.TotalRequests, res.Succeeded, res.Failed,
+		heading,
 		stats.Fastest.Round(time.Microsecond),
 		stats.Slowest.Round(time.Microsecond),
 		stats.Average.Round(time.Microsecond),
-		stats.P50.Round(time.Microsecond),
-		stats.P90.Round(time.Microsecond),
-		stats.P99.Round(time.Microsecond),
+		latency.String(),
 		stats.RPS,
 	)
 	if err != nil {
 		return err
 	}
 
-	if len(res.StatusCodes) > 0 {
-		fmt.Fprintf(w, "Status codes:\n")
-		for code, count := range res.StatusCodes {
-			fmt.Fprintf(w, "  [%d]        %d\n", code, count)
+	if n := len(res.ProxyConnects); n > 0 {
+		var total, slowest time.Duration
+		for _, d := range res.ProxyConnects {
+			total += d
+			slowest = max(slowest, d)
 		}
+		fmt.Fprintf(w, "Proxy CONNECT:\n")
+		fmt.Fprintf(w, "  Tunnels:    %d\n", n)
+		fmt.Fprintf(w, "  Average:    %s\n", (total / time.Duration(n)).Round(time.Microsecond))
+		fmt.Fprintf(w, "  Slowest:    %s\n", slowest.Round(time.Microsecond))
 		fmt.Fprintln(w)
 	}
 
+	if res.DroppedArrivals > 0 {
+		fmt.Fprintf(w, "Dropped:      %d arrivals found every worker busy; raise -concurrency to sustain -rate\n\n", res.DroppedArrivals)
+	}
+
+	if cfg.LogRequests != "" {
+		fmt.Fprintf(w, "Request log:  %s, %d requests written", cfg.LogRequests, res.LoggedRequests)
+		if res.DroppedLogEntries > 0 {
+			fmt.Fprintf(w, ", %d dropped because the log fell behind; lower -log-sample", res.DroppedLogEntries)
+		}
+		fmt.Fprintf(w, "\n\n")
+	}
+
+	if cfg.OTLPEndpoint != "" {
+		fmt.Fprintf(w, "Spans:        %d exported to %s", res.ExportedSpans, cfg.OTLPEndpoint)
+		if res.DroppedSpans > 0 {
+			fmt.Fprintf(w, ", %d dropped", res.DroppedSpans)
+			if res.SpanError != "" {
+				fmt.Fprintf(w, " (%s)", res.SpanError)
+			}
+		}
+		fmt.Fprintf(w, "\n\n")
+	}
+
+	if res.SinkFailures > 0 {
+		fmt.Fprintf(w, "Sink errors:  %d of %d pushes failed: %s\n\n", res.SinkFailures, res.SinkPushes, res.SinkError)
+	}
+
+	if cfg.Think != "" || cfg.Pacing > 0 {
+		printPauses(w, cfg, res)
+	}
+
+	if len(res.Steps) > 0 {
+		printFlow(w, res)
+	}
+
+	if len(res.Adaptive) > 0 {
+		printAdaptive(w, cfg, res)
+	}
+
+	if h.SuccessOnly {
+		printBreakdown(w, cfg, h.all, res)
+	}
+
+	if len(h.all) > 0 {
+		printHistogram(w, cfg.HistogramBuckets, h.all)
+	}
+
+	if len(res.Slowest) > 0 {
+		printSlowest(w, pcts, res)
+	}
+
+	if len(cfg.Thresholds) > 0 {
+		printChecks(w, Checks(cfg, res))
+	}
+
+	if len(res.StatusCodes) > 0 {
+		printStatusCodes(w, res)
+	}
+
 	if len(res.Errors) > 0 {
 		fmt.Fprintf(w, "Errors:\n")
-		for msg, count := range res.Errors {
-			fmt.Fprintf(w, "  (%d) %s\n", count, msg)
+		for _, msg := range sortedErrors(res.Errors) {
+			fmt.Fprintf(w, "  (%d) %s\n", res.Errors[msg], msg)
 		}
 		fmt.Fprintln(w)
 	}
 
 	return nil
 }
+
+// printStatusCodes lists the status codes in numeric order, grouped by
+// class, each with its share of all requests.
+func printStatusCodes(w io.Writer, res engine.Result) {
+	total := res.TotalRequests
+	if total == 0 {
+		for _, n := range res.StatusCodes {
+			total += n
+		}
+	}
+	width := len(strconv.Itoa(total))
+	share := func(n int) float64 { return 100 * float64(n) / float64(total) }
+
+	fmt.Fprintf(w, "Status codes:\n")
+	codes := slices.Sorted(maps.Keys(res.StatusCodes))
+	for i := 0; i < len(codes); {
+		class := codes[i] / 100
+		j, n := i, 0
+		for ; j < len(codes) && codes[j]/100 == class; j++ {
+			n += res.StatusCodes[codes[j]]
+		}
+		fmt.Fprintf(w, "  %-9s %*d  %5.1f%%\n", fmt.Sprintf("%dxx", class), width, n, share(n))
+		for _, code := range codes[i:j] {
+			fmt.Fprintf(w, "    %-7s %*d  %5.1f%%\n", fmt.Sprintf("[%d]", code), width, res.StatusCodes[code], share(res.StatusCodes[code]))
+		}
+		i = j
+	}
+	fmt.Fprintln(w)
+}
+
+// sortedErrors returns the error messages of errs, most frequent first
+// and alphabetically among equals.
+func sortedErrors(errs map[string]int) []string {
+	return slices.SortedFunc(maps.Keys(errs), func(a, b string) int {
+		if c := cmp.Compare(errs[b], errs[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+}
+
+// percentile is a named latency percentile, such as P99.9.
+type percentile struct {
+	name string
+	d    time.Duration
+}
+
+// percentiles computes the percentiles the report shows, those in
+// cfg or, for runs saved without them, the defaults.
+func percentiles(cfg config.Config, sorted []time.Duration) []percentile {
+	ps := cfg.Percentiles
+	if len(ps) == 0 {
+		ps = config.DefaultPercentiles
+	}
+	out := make([]percentile, len(ps))
+	for i, p := range ps {
+		out[i].name = "P" + strconv.FormatFloat(p, 'g', -1, 64)
+		if len(sorted) > 0 {
+			out[i].d = Percentile(sorted, p)
+		}
+	}
+	return out
+}
+
+// Headline holds the figures every report format leads with, computed
+// in one place so that the formats cannot disagree.
+type Headline struct {
+	// Stats covers the latencies the report headlines: those of
+	// successful requests once any fail, as fast failures would flatter
+	// the latency, and otherwise all of them. RPS counts every request.
+	Stats
+	// ErrorRate is the percentage of requests that failed.
+	ErrorRate float64
+	// SuccessOnly reports whether Stats leaves out failed requests.
+	SuccessOnly bool
+
+	// all holds every latency and latencies those Stats covers, both
+	// sorted.
+	all, latencies []time.Duration
+}
+
+// ComputeHeadline computes the headline figures of a run.
+func ComputeHeadline(res engine.Result) Headline {
+	h := Headline{all: sortLatencies(res.Latencies)}
+	h.latencies = h.all
+	if res.HasStatuses() && res.Failed > 0 {
+		h.latencies, h.SuccessOnly = sortLatencies(res.SuccessLatencies()), true
+	}
+	h.Stats = computeSorted(h.latencies)
+	if res.TotalDuration > 0 {
+		h.RPS = float64(res.TotalRequests) / res.TotalDuration.Seconds()
+	}
+	if res.TotalRequests > 0 {
+		h.ErrorRate = 100 * float64(res.Failed) / float64(res.TotalRequests)
+	}
+	return h
+}
+
+// printBreakdown writes a table of latency statistics for all requests,
+// successes, failures, each status code and errors.
+func printBreakdown(w io.Writer, cfg config.Config, sorted []time.Duration, res engine.Result) {
+	fmt.Fprintf(w, "Latency by outcome:\n")
+	fmt.Fprintf(w, "  %-10s %8s %11s", "Outcome", "Count", "Average")
+	for _, p := range percentiles(cfg, nil) {
+		fmt.Fprintf(w, " %11s", p.name)
+	}
+	fmt.Fprintln(w)
+
+	row := func(name string, sorted []time.Duration) {
+		s := computeSorted(sorted)
+		fmt.Fprintf(w, "  %-10s %8d %11s", name, len(sorted), s.Average.Round(time.Microsecond))
+		for _, p := range percentiles(cfg, sorted) {
+			fmt.Fprintf(w, " %11s", p.d.Round(time.Microsecond))
+		}
+		fmt.Fprintln(w)
+	}
+	row("all", sorted)
+	row("success", sortLatencies(res.SuccessLatencies()))
+	row("failure", sortLatencies(res.FailureLatencies()))
+	byStatus := res.LatencyByStatus()
+	for _, code := range slices.Sorted(maps.Keys(byStatus)) {
+		row(fmt.Sprintf("[%d]", code), sortLatencies(byStatus[code]))
+	}
+	if errs := res.ErrorLatencies(); len(errs) > 0 {
+		row("errors", sortLatencies(errs))
+	}
+	fmt.Fprintln(w)
+}
+
+// printPauses writes the idle time spent thinking and pacing, which is
+// excluded from latency.
+func printPauses(w io.Writer, cfg config.Config, res engine.Result) {
+	fmt.Fprintf(w, "Pauses (not included in latency):\n")
+	if cfg.Think != "" {
+		var avg time.Duration
+		if res.TotalRequests > 0 {
+			avg = res.ThinkTime / time.Duration(res.TotalRequests)
+		}
+		fmt.Fprintf(w, "  Think:      %s total, %s avg per request (%s)\n",
+			res.ThinkTime.Round(time.Millisecond), avg.Round(time.Microsecond), cfg.Think)
+	}
+	if cfg.Pacing > 0 {
+		fmt.Fprintf(w, "  Pacing:     every %s, %s waiting, %d iterations overran\n",
+			cfg.Pacing, res.PacingWait.Round(time.Millisecond), res.PacingOverruns)
+	}
+	fmt.Fprintln(w)
+}
+
+// printFlow writes per-step and whole-flow (transaction) results.
+func printFlow(w io.Writer, res engine.Result) {
+	fmt.Fprintf(w, "Steps:\n")
+	for _, g := range res.Steps {
+		printGroup(w, g)
+	}
+	fmt.Fprintln(w)
+
+	tx := res.Transactions
+	var rate float64
+	if tx.Count > 0 {
+		rate = 100 * float64(tx.Count-tx.Failed) / float64(tx.Count)
+	}
+	fmt.Fprintf(w, "Transactions: %d total, %d succeeded, %d failed (%.2f%% success)\n",
+		tx.Count, tx.Count-tx.Failed, tx.Failed, rate)
+	printGroup(w, tx)
+	fmt.Fprintln(w)
+}
+
+// printAdaptive writes the trajectory of the adaptive concurrency
+// controller and the concurrency it settled on.
+func printAdaptive(w io.Writer, cfg config.Config, res engine.Result) {
+	// The first half of the run is spent finding the target, so judge
+	// the sustainable concurrency by the second.
+	settled := res.Adaptive[len(res.Adaptive)/2:]
+	var sum int
+	for _, s := range settled {
+		sum += s.Workers
+	}
+	fmt.Fprintf(w, "Adaptive concurrency (P95 target %s):\n", cfg.AdaptiveP95)
+	fmt.Fprintf(w, "  Sustained:  %.1f workers (average over the second half of the run)\n",
+		float64(sum)/float64(len(settled)))
+	fmt.Fprintf(w, "  %10s  %7s  %8s  %s\n", "Time", "Workers", "Requests", "P95")
+	for _, s := range res.Adaptive {
+		fmt.Fprintf(w, "  %10s  %7d  %8d  %s\n",
+			s.Elapsed.Round(time.Millisecond), s.Workers, s.Requests, s.P95.Round(time.Microsecond))
+	}
+	fmt.Fprintln(w)
+}
+
+// printSlowest lists the slowest requests and, for each percentile, a
+// request from the same latency bucket, so they can be looked up in the
+// target's logs or traces.
+func printSlowest(w io.Writer, pcts []percentile, res engine.Result) {
+	fmt.Fprintf(w, "Slowest requests:\n")
+	fmt.Fprintf(w, "  %-6s %-10s  %-12s  %6s  %6s  %-6s  %s\n", "", "Latency", "Time", "Worker", "Status", "Conn", "Request")
+	for _, r := range res.Slowest {
+		printRequest(w, "", r)
+	}
+	fmt.Fprintln(w)
+
+	if len(res.Exemplars) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Exemplars (latest request in the same latency bucket):\n")
+	for _, p := range pcts {
+		if r, ok := res.Exemplar(p.d); ok {
+			printRequest(w, p.name, r)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func printRequest(w io.Writer, label string, r engine.Request) {
+	status, conn := strconv.Itoa(r.Status), "new"
+	if r.Error != "" {
+		status = "error"
+	}
+	if r.Reused {
+		conn = "reused"
+	}
+	target := r.Method + " " + r.URL
+	if r.Step != "" {
+		target = r.Step + ": " + target
+	}
+	if r.TraceID != "" {
+		target += "  trace " + r.TraceID
+	}
+	if r.Error != "" {
+		target += "  (" + r.Error + ")"
+	}
+	fmt.Fprintf(w, "  %-6s %-10s  %-12s  %6d  %6s  %-6s  %s\n",
+		label, r.Latency.Round(time.Microsecond), r.Time.Format("15:04:05.000"), r.Worker, status, conn, target)
+}
+
+func printGroup(w io.Writer, g engine.Group) {
+	s := ComputeLatencies(g.Latencies)
+	fmt.Fprintf(w, "  %-20s %6d total %6d failed   avg %-10s p50 %-10s p90 %-10s p99 %s\n",
+		g.Name, g.Count, g.Failed,
+		s.Average.Round(time.Microsecond),
+		s.P50.Round(time.Microsecond),
+		s.P90.Round(time.Microsecond),
+		s.P99.Round(time.Microsecond),
+	)
+}
+
+// redactProxy hides any password in the proxy URL so credentials do not
+// end up in saved reports.
+func redactProxy(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+)
+
+// Check is the outcome of one threshold.
+type Check struct {
+	Threshold config.Threshold
+	// Measured is the value of the metric, in the threshold's unit.
+	Measured float64
+	Pass     bool
+}
+
+// Checks evaluates the thresholds in cfg against a run. Latency
+// thresholds apply to the latency the report headlines. A run without
+// requests fails every threshold.
+func Checks(cfg config.Config, res engine.Result) []Check {
+	if len(cfg.Thresholds) == 0 {
+		return nil
+	}
+	h := ComputeHeadline(res)
+	checks := make([]Check, 0, len(cfg.Thresholds))
+	for _, raw := range cfg.Thresholds {
+		// Validated by config.Parse.
+		t, _ := config.ParseThreshold(raw)
+		c := Check{Threshold: t}
+		switch {
+		case t.Metric == "rps":
+			c.Measured = h.RPS
+		case t.Metric == "requests":
+			c.Measured = float64(res.TotalRequests)
+		case t.Metric == "errors":
+			c.Measured = h.ErrorRate
+		case len(h.latencies) == 0:
+		case t.Metric == "avg":
+			c.Measured = h.Average.Seconds()
+		case t.Metric == "max":
+			c.Measured = h.Slowest.Seconds()
+		default:
+			p, _ := t.Percentile()
+			c.Measured = Percentile(h.latencies, p).Seconds()
+		}
+		c.Pass = res.TotalRequests > 0 && t.Allows(c.Measured)
+		if t.IsLatency() && len(h.latencies) == 0 {
+			c.Pass = false
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// Failed returns the checks that did not pass.
+func Failed(checks []Check) []Check {
+	var out []Check
+	for _, c := range checks {
+		if !c.Pass {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Message describes the measured value against the threshold, such as
+// "p99 was 612ms, want < 500ms".
+func (c Check) Message() string {
+	t := c.Threshold
+	return fmt.Sprintf("%s was %s, want %s %s", t.Metric, t.Format(c.Measured), t.Op, t.Format(t.Value))
+}
+
+func printChecks(w io.Writer, checks []Check) {
+	fmt.Fprintf(w, "Thresholds:   %d of %d failed\n", len(Failed(checks)), len(checks))
+	for _, c := range checks {
+		verdict := "pass"
+		if !c.Pass {
+			verdict = "FAIL"
+		}
+		fmt.Fprintf(w, "  %s  %s\n", verdict, c.Message())
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,171 @@
+// Package reqlog writes a log of individual requests as newline-delimited
+// JSON, one object per request, for investigating outliers that
+// aggregates hide.
+//
+// Entries are handed to a background goroutine through a buffered
+// channel, so a slow disk never holds up the caller. When the buffer is
+// full, entries are dropped and counted instead.
+package reqlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"goperf/internal/worker"
+)
+
+// bufferSize is the number of entries that may wait to be written.
+const bufferSize = 1 << 16
+
+// Options selects which requests are logged.
+type Options struct {
+	// Sample is the fraction of eligible requests logged, from 0 to 1.
+	Sample float64
+	// Failed and SlowerThan, when set, make only failed requests or
+	// requests slower than the threshold eligible. With neither set,
+	// every request is.
+	Failed     bool
+	SlowerThan time.Duration
+}
+
+// Entry is one line of the log. Times are in milliseconds; phases that
+// did not happen on the request, such as connecting on a reused
+// connection, are omitted.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Worker  int       `json:"worker"`
+	Step    string    `json:"step,omitempty"`
+	Method  string    `json:"method"`
+	URL     string    `json:"url"`
+	Status  int       `json:"status,omitempty"`
+	Latency float64   `json:"latency_ms"`
+	Bytes   int64     `json:"bytes"`
+	Error   string    `json:"error,omitempty"`
+	Reused  bool      `json:"reused"`
+	DNS     float64   `json:"dns_ms,omitempty"`
+	Connect float64   `json:"connect_ms,omitempty"`
+	TLS     float64   `json:"tls_ms,omitempty"`
+	TTFB    float64   `json:"ttfb_ms,omitempty"`
+	TraceID string    `json:"trace_id,omitempty"`
+}
+
+// Logger filters requests and writes them in the background. Log must be
+// called from a single goroutine.
+type Logger struct {
+	opts    Options
+	rand    *rand.Rand
+	entries chan Entry
+	done    chan error
+
+	written int
+	dropped int
+}
+
+// Create creates the log file at path and starts writing to it.
+func Create(path string, opts Options, r *rand.Rand) (*Logger, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(f, opts, r), nil
+}
+
+// New starts a logger writing to w, closing w on Close if it is an
+// io.Closer. r drives sampling.
+func New(w io.Writer, opts Options, r *rand.Rand) *Logger {
+	l := &Logger{
+		opts:    opts,
+		rand:    r,
+		entries: make(chan Entry, bufferSize),
+		done:    make(chan error, 1),
+	}
+	go l.write(w)
+	return l
+}
+
+func (l *Logger) write(w io.Writer) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var err error
+	for e := range l.entries {
+		if err == nil {
+			err = enc.Encode(e)
+		}
+		if err == nil {
+			l.written++
+		}
+	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if c, ok := w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	l.done <- err
+}
+
+// Log queues a request for writing if it passes the filters. step names
+// the flow step, if any. Results without a Detail are ignored.
+func (l *Logger) Log(rr worker.Result, step string) {
+	d := rr.Detail
+	if d == nil || !l.eligible(rr) {
+		return
+	}
+	if l.opts.Sample < 1 && l.rand.Float64() >= l.opts.Sample {
+		return
+	}
+
+	e := Entry{
+		Time:    d.Start,
+		Worker:  d.Worker,
+		Step:    step,
+		Method:  d.Method,
+		URL:     d.URL,
+		Status:  rr.StatusCode,
+		Latency: ms(rr.Duration),
+		Bytes:   d.Bytes,
+		Reused:  d.Reused,
+		DNS:     ms(d.DNS),
+		Connect: ms(d.Connect),
+		TLS:     ms(d.TLS),
+		TTFB:    ms(d.TTFB),
+	}
+	if rr.Error != nil {
+		e.Error = rr.Error.Error()
+	}
+	if rr.Trace.IsValid() {
+		e.TraceID = rr.Trace.Trace()
+	}
+	select {
+	case l.entries <- e:
+	default:
+		l.dropped++
+	}
+}
+
+func (l *Logger) eligible(rr worker.Result) bool {
+	if !l.opts.Failed && l.opts.SlowerThan <= 0 {
+		return true
+	}
+	failed := rr.Error != nil || rr.StatusCode >= 400
+	slow := l.opts.SlowerThan > 0 && rr.Duration > l.opts.SlowerThan
+	return l.opts.Failed && failed || slow
+}
+
+// Close writes any queued entries, closes the log and returns the number
+// of entries written and dropped.
+func (l *Logger) Close() (written, dropped int, err error) {
+	close(l.entries)
+	err = <-l.done
+	return l.written, l.dropped, err
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
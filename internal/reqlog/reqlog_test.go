@@ -0,0 +1,154 @@
+package reqlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"goperf/internal/trace"
+	"goperf/internal/worker"
+)
+
+func result(d time.Duration, status int, err error) worker.Result {
+	return worker.Result{
+		Duration:   d,
+		StatusCode: status,
+		Error:      err,
+		Detail: &worker.Detail{
+			Start:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			Worker:  1,
+			Method:  "GET",
+			URL:     "http://example.com/",
+			Bytes:   42,
+			Connect: 2 * time.Millisecond,
+			TTFB:    d,
+		},
+	}
+}
+
+func decode(t *testing.T, data []byte) []Entry {
+	t.Helper()
+	var out []Entry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q is not JSON: %v", sc.Text(), err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func TestLogWritesEntries(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, Options{Sample: 1}, rand.New(rand.NewPCG(1, 1)))
+	l.Log(result(5*time.Millisecond, 200, nil), "login")
+	traced := result(7*time.Millisecond, 0, errors.New("connection refused"))
+	traced.Trace = trace.New()
+	l.Log(traced, "")
+	l.Log(worker.Result{Duration: time.Millisecond}, "") // no detail
+	written, dropped, err := l.Close()
+	if err != nil || written != 2 || dropped != 0 {
+		t.Fatalf("Close = %d, %d, %v; want 2, 0, nil", written, dropped, err)
+	}
+
+	entries := decode(t, buf.Bytes())
+	if len(entries) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(entries), buf.String())
+	}
+	want := Entry{
+		Time:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Worker:  1,
+		Step:    "login",
+		Method:  "GET",
+		URL:     "http://example.com/",
+		Status:  200,
+		Latency: 5,
+		Bytes:   42,
+		Connect: 2,
+		TTFB:    5,
+	}
+	if entries[0] != want {
+		t.Errorf("entry = %+v, want %+v", entries[0], want)
+	}
+	if entries[1].Error != "connection refused" || entries[1].Status != 0 || entries[1].TraceID != traced.Trace.Trace() {
+		t.Errorf("failed entry = %+v", entries[1])
+	}
+	if bytes.Contains(buf.Bytes(), []byte("dns_ms")) {
+		t.Errorf("phases that did not happen should be omitted:\n%s", buf.String())
+	}
+}
+
+func TestLogFilters(t *testing.T) {
+	fast := result(time.Millisecond, 200, nil)
+	slow := result(time.Second, 200, nil)
+	failed := result(time.Millisecond, 503, nil)
+
+	tests := []struct {
+		name string
+		opts Options
+		want int
+	}{
+		{"everything", Options{Sample: 1}, 3},
+		{"failures", Options{Sample: 1, Failed: true}, 1},
+		{"slow", Options{Sample: 1, SlowerThan: 100 * time.Millisecond}, 1},
+		{"failures or slow", Options{Sample: 1, Failed: true, SlowerThan: 100 * time.Millisecond}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(&bytes.Buffer{}, tt.opts, rand.New(rand.NewPCG(1, 1)))
+			for _, rr := range []worker.Result{fast, slow, failed} {
+				l.Log(rr, "")
+			}
+			if written, _, _ := l.Close(); written != tt.want {
+				t.Errorf("wrote %d entries, want %d", written, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogSamples(t *testing.T) {
+	l := New(&bytes.Buffer{}, Options{Sample: 0.1}, rand.New(rand.NewPCG(1, 1)))
+	rr := result(time.Millisecond, 200, nil)
+	for range 10000 {
+		l.Log(rr, "")
+	}
+	if written, _, _ := l.Close(); written < 800 || written > 1200 {
+		t.Errorf("wrote %d of 10000 entries at 10%%, want about 1000", written)
+	}
+}
+
+// blockingWriter blocks every write until released.
+type blockingWriter struct{ release chan struct{} }
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestLogDropsWhenBehind(t *testing.T) {
+	w := blockingWriter{release: make(chan struct{})}
+	l := New(w, Options{Sample: 1}, rand.New(rand.NewPCG(1, 1)))
+	rr := result(time.Millisecond, 200, nil)
+	// Enough to fill the bufio buffer, block the writer and fill the queue.
+	start := time.Now()
+	for range 2 * bufferSize {
+		l.Log(rr, "")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("logging blocked for %s", elapsed)
+	}
+	close(w.release)
+	written, dropped, err := l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped == 0 || written+dropped != 2*bufferSize {
+		t.Errorf("written %d, dropped %d of %d", written, dropped, 2*bufferSize)
+	}
+}
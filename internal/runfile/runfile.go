@@ -0,0 +1,185 @@
+// Package runfile saves load test runs to disk and loads them back, so
+// reports can be rendered and runs compared without re-running a test.
+//
+// A run file is a JSON document holding the configuration and the full
+// result, including every latency sample and the timeline. It is
+// gzip-compressed unless the file name ends in .json.
+//
+// Run files get shared, so credentials are redacted before saving:
+// passwords in the configured and recorded request URLs and the values
+// of headers such as Authorization and Cookie.
+package runfile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+	"goperf/internal/sink"
+)
+
+// Version is the run file format version written by this build. Files
+// from newer versions are rejected.
+const Version = 1
+
+// Run is a saved load test run.
+type Run struct {
+	Version int           `json:"version"`
+	Saved   time.Time     `json:"saved"`
+	Config  config.Config `json:"config"`
+	Result  engine.Result `json:"result"`
+}
+
+// New returns a Run of the current version saved now, with the
+// credentials in cfg and the request URLs of res redacted.
+func New(cfg config.Config, res engine.Result) Run {
+	res.Slowest = redactRequests(res.Slowest)
+	res.Exemplars = redactRequests(res.Exemplars)
+	return Run{Version: Version, Saved: time.Now().UTC(), Config: redact(cfg), Result: res}
+}
+
+// redactRequests returns a copy of reqs with URL passwords redacted.
+// Workers already record redacted URLs; this covers results built
+// elsewhere.
+func redactRequests(reqs []engine.Request) []engine.Request {
+	reqs = slices.Clone(reqs)
+	for i := range reqs {
+		reqs[i].URL = redactURL(reqs[i].URL)
+	}
+	return reqs
+}
+
+// sensitiveHeaders are substrings of the lower-case names of headers
+// whose values are redacted.
+var sensitiveHeaders = []string{"authorization", "cookie", "token", "secret", "password", "api-key", "apikey", "session"}
+
+// redacted replaces secret values in a run file.
+const redacted = "xxxxx"
+
+// redact returns a copy of cfg without the credentials it may hold,
+// often expanded from the environment: URL passwords and sensitive
+// header values.
+func redact(cfg config.Config) config.Config {
+	cfg.URL = redactURL(cfg.URL)
+	cfg.Proxy = redactURL(cfg.Proxy)
+	cfg.OTLPEndpoint = redactURL(cfg.OTLPEndpoint)
+
+	cfg.Headers = slices.Clone(cfg.Headers)
+	for i, h := range cfg.Headers {
+		key, _, err := config.SplitHeader(h)
+		if err != nil {
+			continue
+		}
+		name := strings.ToLower(key)
+		if slices.ContainsFunc(sensitiveHeaders, func(s string) bool { return strings.Contains(name, s) }) {
+			cfg.Headers[i] = key + ": " + redacted
+		}
+	}
+
+	cfg.Sinks = slices.Clone(cfg.Sinks)
+	for i, raw := range cfg.Sinks {
+		cfg.Sinks[i] = sink.Redact(raw)
+	}
+	return cfg
+}
+
+// redactURL hides the password of a URL, leaving any other URL,
+// including templates, exactly as written.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); !ok {
+		return raw
+	}
+	// Replace the user info in place, as re-encoding the URL would
+	// escape the braces of any placeholders.
+	if userinfo := u.User.String() + "@"; strings.Contains(raw, userinfo) {
+		return strings.Replace(raw, userinfo, url.UserPassword(u.User.Username(), redacted).String()+"@", 1)
+	}
+	return u.Redacted()
+}
+
+// Save writes run to path, gzip-compressed unless path ends in .json.
+func Save(path string, run Run) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	if strings.HasSuffix(path, ".json") {
+		err = Write(bw, run)
+	} else {
+		zw := gzip.NewWriter(bw)
+		if err = Write(zw, run); err == nil {
+			err = zw.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Write encodes run as JSON.
+func Write(w io.Writer, run Run) error {
+	return json.NewEncoder(w).Encode(run)
+}
+
+// Load reads a run file written by Save, compressed or not.
+func Load(path string) (*Run, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	run, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return run, nil
+}
+
+// Read decodes a run, detecting gzip compression from its header.
+func Read(r io.Reader) (*Run, error) {
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		src = zr
+	}
+
+	var run Run
+	if err := json.NewDecoder(src).Decode(&run); err != nil {
+		return nil, fmt.Errorf("not a goperf run file: %w", err)
+	}
+	switch {
+	case run.Version == 0:
+		return nil, errors.New("not a goperf run file: no version")
+	case run.Version > Version:
+		return nil, fmt.Errorf("run file version %d is newer than this goperf supports (%d)", run.Version, Version)
+	}
+	return &run, nil
+}
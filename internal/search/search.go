@@ -0,0 +1,169 @@
+// Package search finds the highest load a target sustains within a
+// latency and error rate SLO.
+//
+// Load doubles from the start level until a step misses the SLO or the
+// maximum is reached, then the knee between the last passing and first
+// failing level is located by binary search.
+package search
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+	"goperf/internal/report"
+)
+
+// RunFunc executes one load step.
+type RunFunc func(config.Config) (engine.Result, error)
+
+// Step is the outcome of one load level.
+type Step struct {
+	Load      float64
+	Requests  int
+	RPS       float64
+	P99       time.Duration // of successes once any fail, as reported
+	ErrorRate float64       // percent
+	// Dropped counts arrivals at the target rate that found every worker
+	// busy. A step that dropped any never ran at the load it names, so it
+	// fails whatever its latency.
+	Dropped int
+	Pass    bool
+}
+
+// Outcome lists every step in the order it ran and the highest load that
+// met the SLO, which is zero when none did.
+type Outcome struct {
+	Steps []Step
+	Best  float64
+}
+
+// Run searches for the highest load meeting the SLO in s, executing each
+// step with run. onStep, if non-nil, is called as each step completes.
+func Run(cfg config.Config, s config.Search, run RunFunc, onStep func(Step)) (Outcome, error) {
+	var out Outcome
+	try := func(load float64) (bool, error) {
+		c := cfg
+		if s.Mode == "rate" {
+			c.Rate = load
+		} else {
+			c.Concurrency = int(load)
+		}
+		res, err := run(c)
+		if err != nil {
+			return false, err
+		}
+		st := evaluate(load, res, s)
+		out.Steps = append(out.Steps, st)
+		if onStep != nil {
+			onStep(st)
+		}
+		return st.Pass, nil
+	}
+
+	// Ramp up until the SLO is missed. lo is the highest passing load and
+	// hi the lowest failing one, zero until seen.
+	var lo, hi float64
+	for load := level(s, s.Start); ; {
+		pass, err := try(load)
+		if err != nil {
+			return out, err
+		}
+		if !pass {
+			hi = load
+			break
+		}
+		lo = load
+		next := level(s, min(load*2, s.Max))
+		if next <= load {
+			break
+		}
+		load = next
+	}
+
+	// Narrow down the knee.
+	for lo > 0 && hi > 0 && hi-lo > s.Precision*lo {
+		mid := level(s, (lo+hi)/2)
+		if mid <= lo || mid >= hi {
+			break
+		}
+		pass, err := try(mid)
+		if err != nil {
+			return out, err
+		}
+		if pass {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	out.Best = lo
+	return out, nil
+}
+
+// evaluate checks one step's result against the SLO.
+func evaluate(load float64, res engine.Result, s config.Search) Step {
+	h := report.ComputeHeadline(res)
+	st := Step{
+		Load:      load,
+		Requests:  res.TotalRequests,
+		RPS:       h.RPS,
+		P99:       h.P99,
+		ErrorRate: h.ErrorRate,
+		Dropped:   res.DroppedArrivals,
+	}
+	st.Pass = res.TotalRequests > 0 && st.Dropped == 0 && st.P99 <= s.P99 && st.ErrorRate <= s.ErrorRate
+	return st
+}
+
+// Print writes a table of every step and the highest load meeting the SLO.
+func Print(w io.Writer, s config.Search, out Outcome) error {
+	_, err := fmt.Fprintf(w, `
+--- goperf find-max ---
+SLO:          P99 <= %s, errors <= %g%%
+
+Step  %11s   Requests         RPS         P99   Errors  SLO
+`, s.P99, s.ErrorRate, s.Mode)
+	if err != nil {
+		return err
+	}
+
+	for i, st := range out.Steps {
+		verdict := "pass"
+		if !st.Pass {
+			verdict = "FAIL"
+		}
+		if st.Dropped > 0 {
+			verdict += fmt.Sprintf(", %d arrivals dropped", st.Dropped)
+		}
+		fmt.Fprintf(w, "%4d  %11g  %9d  %10.2f  %10s  %6.2f%%  %s\n",
+			i+1, st.Load, st.Requests, st.RPS, st.P99.Round(time.Microsecond), st.ErrorRate, verdict)
+	}
+	fmt.Fprintln(w)
+
+	switch {
+	case out.Best == 0 && slices.ContainsFunc(out.Steps, func(st Step) bool { return st.Dropped > 0 }):
+		fmt.Fprintf(w, "No load level met the SLO; arrivals were dropped, so raise -concurrency or lower -start.\n")
+	case out.Best == 0:
+		fmt.Fprintf(w, "No load level met the SLO; lower -start.\n")
+	case len(out.Steps) > 0 && out.Steps[len(out.Steps)-1].Pass && out.Best >= s.Max:
+		fmt.Fprintf(w, "Highest %s meeting SLO: %g (reached -max; the limit may be higher)\n", s.Mode, out.Best)
+	default:
+		fmt.Fprintf(w, "Highest %s meeting SLO: %g\n", s.Mode, out.Best)
+	}
+	return nil
+}
+
+// level rounds a load to one the mode can run: whole workers for
+// concurrency, hundredths of a request per second for rate.
+func level(s config.Search, load float64) float64 {
+	if s.Mode == "rate" {
+		return max(0.01, math.Round(load*100)/100)
+	}
+	return max(1, math.Round(load))
+}
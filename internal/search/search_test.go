@@ -0,0 +1,167 @@
+package search
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"goperf/internal/config"
+	"goperf/internal/engine"
+)
+
+// fakeTarget returns a RunFunc simulating a service whose P99 stays at
+// 10ms up to capacity and jumps to 1s beyond it.
+func fakeTarget(capacity float64, mode string, loads *[]float64) RunFunc {
+	return func(c config.Config) (engine.Result, error) {
+		load := float64(c.Concurrency)
+		if mode == "rate" {
+			load = c.Rate
+		}
+		*loads = append(*loads, load)
+		lat := 10 * time.Millisecond
+		if load > capacity {
+			lat = time.Second
+		}
+		lats := make([]time.Duration, 100)
+		for i := range lats {
+			lats[i] = lat
+		}
+		return engine.Result{
+			TotalRequests: 100,
+			Succeeded:     100,
+			Latencies:     lats,
+			TotalDuration: time.Second,
+		}, nil
+	}
+}
+
+func search(mode string) config.Search {
+	return config.Search{
+		Mode:      mode,
+		Start:     1,
+		Max:       1024,
+		P99:       500 * time.Millisecond,
+		ErrorRate: 1,
+		Precision: 0.05,
+	}
+}
+
+func TestRunFindsKnee(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		capacity float64
+		max      float64
+		wantMin  float64
+		wantMax  float64
+	}{
+		{"concurrency", "concurrency", 37, 1024, 37, 37},
+		{"rate", "rate", 450, 1024, 450 / 1.05, 450},
+		{"capped at max", "concurrency", 5000, 100, 100, 100},
+		{"none pass", "concurrency", 0, 1024, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := search(tt.mode)
+			s.Max = tt.max
+			var loads []float64
+			var seen int
+			out, err := Run(config.Config{}, s, fakeTarget(tt.capacity, tt.mode, &loads), func(Step) { seen++ })
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.Best < tt.wantMin || out.Best > tt.wantMax {
+				t.Errorf("best = %g, want in [%g, %g] (steps %v)", out.Best, tt.wantMin, tt.wantMax, loads)
+			}
+			if seen != len(out.Steps) || len(out.Steps) != len(loads) {
+				t.Errorf("onStep called %d times for %d steps and %d runs", seen, len(out.Steps), len(loads))
+			}
+			if len(loads) > 30 {
+				t.Errorf("search took %d steps", len(loads))
+			}
+		})
+	}
+}
+
+func TestRunCountsErrorsAndEmptySteps(t *testing.T) {
+	tests := []struct {
+		name string
+		res  engine.Result
+		pass bool
+	}{
+		{"within error slo", engine.Result{TotalRequests: 100, Succeeded: 99, Failed: 1, Latencies: make([]time.Duration, 100)}, true},
+		{"over error slo", engine.Result{TotalRequests: 100, Succeeded: 98, Failed: 2, Latencies: make([]time.Duration, 100)}, false},
+		{"no requests", engine.Result{}, false},
+		{"dropped arrivals", engine.Result{TotalRequests: 100, Succeeded: 100, Latencies: make([]time.Duration, 100), DroppedArrivals: 5}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := evaluate(1, tt.res, search("concurrency"))
+			if st.Pass != tt.pass {
+				t.Errorf("pass = %v, want %v (error rate %g%%)", st.Pass, tt.pass, st.ErrorRate)
+			}
+		})
+	}
+}
+
+// TestRunFailsDroppedArrivals checks that in rate mode a level the
+// workers could not keep up with fails, even though the requests that
+// were sent met the SLO.
+func TestRunFailsDroppedArrivals(t *testing.T) {
+	var loads []float64
+	target := fakeTarget(5000, "rate", &loads)
+	run := func(c config.Config) (engine.Result, error) {
+		res, err := target(c)
+		if c.Rate > 200 {
+			res.DroppedArrivals = int(c.Rate) - 200
+		}
+		return res, err
+	}
+	s := search("rate")
+	out, err := Run(config.Config{}, s, run, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.Best < 200/1.05 || out.Best > 200 {
+		t.Errorf("best = %g, want in [%g, 200] (steps %v)", out.Best, 200/1.05, loads)
+	}
+
+	var buf bytes.Buffer
+	if err := Print(&buf, s, out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "FAIL, 56 arrivals dropped") {
+		t.Errorf("output does not show the dropped arrivals:\n%s", buf.String())
+	}
+}
+
+func TestRunStopsOnError(t *testing.T) {
+	boom := errors.New("boom")
+	_, err := Run(config.Config{}, search("concurrency"), func(config.Config) (engine.Result, error) {
+		return engine.Result{}, boom
+	}, nil)
+	if !errors.Is(err, boom) {
+		t.Errorf("err = %v, want %v", err, boom)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var loads []float64
+	s := search("concurrency")
+	out, err := Run(config.Config{}, s, fakeTarget(12, "concurrency", &loads), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Print(&buf, s, out); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{"SLO:          P99 <= 500ms, errors <= 1%", "FAIL", "Highest concurrency meeting SLO: 12"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"goperf/internal/metrics"
+)
+
+// graphite writes the Graphite plaintext protocol over TCP.
+type graphite struct {
+	addr   string
+	prefix string
+	conn   net.Conn // nil until dialed by a write
+}
+
+func newGraphite(u *url.URL) *graphite {
+	return &graphite{addr: u.Host, prefix: prefix(u)}
+}
+
+func (s *graphite) dial() error {
+	conn, err := net.DialTimeout("tcp", s.addr, Timeout)
+	if err != nil {
+		return fmt.Errorf("graphite: %w", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// Write sends one line per point, with any label value as the last
+// path component:
+//
+//	goperf.requests_total.200 1520 1700000000
+//	goperf.request_duration_seconds_bucket.le_0_25 1498 1700000000
+func (s *graphite) Write(snap metrics.Snapshot) error {
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+	var b bytes.Buffer
+	ts := snap.Time.Unix()
+	for _, p := range points(snap) {
+		fmt.Fprintf(&b, "%s %s %d\n", path(s.prefix, p), formatFloat(p.v), ts)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(Timeout))
+	if _, err := s.conn.Write(b.Bytes()); err != nil {
+		// Drop the connection so the next write reconnects.
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("graphite: %w", err)
+	}
+	return nil
+}
+
+func (s *graphite) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// prefix returns the metric path prefix of a graphite or statsd URL.
+func prefix(u *url.URL) string {
+	if p := u.Query().Get("prefix"); p != "" {
+		return p
+	}
+	return "goperf"
+}
+
+// path returns the dotted metric path of p. A histogram bound becomes
+// a component such as "le_0_005" or "le_Inf", as dots separate paths.
+func path(prefix string, p point) string {
+	switch p.label {
+	case "":
+		return prefix + "." + p.name
+	case "le":
+		return prefix + "." + p.name + ".le_" + strings.NewReplacer(".", "_", "+", "").Replace(p.value)
+	}
+	return prefix + "." + p.name + "." + p.value
+}
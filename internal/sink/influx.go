@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"goperf/internal/metrics"
+)
+
+// influx writes InfluxDB line protocol to an HTTP write endpoint, or an
+// HTTPS one for the influxs scheme.
+type influx struct {
+	url    string
+	user   *url.Userinfo
+	client *http.Client
+}
+
+func newInflux(u *url.URL) *influx {
+	endpoint := *u
+	endpoint.Scheme = "http"
+	if u.Scheme == "influxs" {
+		endpoint.Scheme = "https"
+	}
+	endpoint.User = nil
+	if endpoint.Path == "" {
+		endpoint.Path = "/write"
+	}
+	return &influx{url: endpoint.String(), user: u.User, client: &http.Client{Timeout: Timeout}}
+}
+
+// Write posts one line per point, each a measurement named after the
+// metric with a single "value" field:
+//
+//	goperf_requests_total,code=200 value=1520i 1700000000000000000
+func (s *influx) Write(snap metrics.Snapshot) error {
+	var b bytes.Buffer
+	ts := snap.Time.UnixNano()
+	for _, p := range points(snap) {
+		b.WriteString("goperf_" + p.name)
+		if p.label != "" {
+			fmt.Fprintf(&b, ",%s=%s", p.label, p.value)
+		}
+		if p.integer {
+			fmt.Fprintf(&b, " value=%di %d\n", int64(p.v), ts)
+		} else {
+			fmt.Fprintf(&b, " value=%s %d\n", formatFloat(p.v), ts)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &b)
+	if err != nil {
+		return fmt.Errorf("influx: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.user != nil {
+		pass, _ := s.user.Password()
+		req.SetBasicAuth(s.user.Username(), pass)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("influx: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influx: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (s *influx) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,182 @@
+// Package sink pushes the live metrics of a running test to external
+// time series stores at a fixed interval.
+//
+// A sink is configured by URL:
+//
+//	influx://host:8086/write?db=goperf   InfluxDB line protocol over HTTP
+//	influxs://host:8086/write?db=goperf  the same over HTTPS
+//	graphite://host:2003?prefix=goperf   Graphite plaintext over TCP
+//	statsd://host:8125?prefix=goperf     StatsD over UDP
+//
+// The influx path and query are passed to the server unchanged, with
+// "/write" used when the path is empty, and user info is sent as basic
+// auth. The graphite and statsd prefix defaults to "goperf".
+//
+// Sinks connect on their first write and reconnect after a failure, so
+// an unreachable store costs failed pushes rather than the run.
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"goperf/internal/metrics"
+)
+
+// Sink receives periodic snapshots of a running test's metrics.
+type Sink interface {
+	Write(metrics.Snapshot) error
+	Close() error
+}
+
+// Timeout bounds each write to a sink, so an unreachable store cannot
+// hold up the end of a run for long.
+const Timeout = 5 * time.Second
+
+// Open returns the sink described by raw.
+func Open(raw string) (Sink, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("sink: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("sink %q has no host", Redact(raw))
+	}
+	switch u.Scheme {
+	case "influx", "influxs":
+		return newInflux(u), nil
+	case "graphite":
+		return newGraphite(u), nil
+	case "statsd":
+		return newStatsD(u), nil
+	default:
+		return nil, fmt.Errorf("sink %q: unsupported scheme %q, want influx, influxs, graphite or statsd", Redact(raw), u.Scheme)
+	}
+}
+
+// Redact hides the credentials in a sink URL, the password of its user
+// info and any InfluxDB password or token in its query, so it can be
+// shown in reports and logs.
+func Redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	for _, key := range []string{"p", "password", "token"} {
+		if q.Has(key) {
+			q.Set(key, "xxxxx")
+			u.RawQuery = q.Encode()
+		}
+	}
+	return u.Redacted()
+}
+
+// point is one value of a snapshot. Series with a label, such as
+// responses by status code, have one point per label value.
+type point struct {
+	name    string
+	label   string // label name, empty for none
+	value   string // label value
+	v       float64
+	counter bool // cumulative since the start of the run
+	integer bool
+}
+
+// points flattens s into the series every sink writes, named like the
+// Prometheus metrics without the "goperf_" prefix. The latency histogram
+// is written as cumulative bucket counts labelled by upper bound, "le".
+func points(s metrics.Snapshot) []point {
+	var ps []point
+	for i, n := range s.Buckets {
+		le := "+Inf"
+		if i < len(metrics.Buckets) {
+			le = formatFloat(metrics.Buckets[i])
+		}
+		ps = append(ps, point{"request_duration_seconds_bucket", "le", le, float64(n), true, true})
+	}
+	for _, code := range slices.Sorted(maps.Keys(s.Statuses)) {
+		ps = append(ps, point{"requests_total", "code", strconv.Itoa(code), float64(s.Statuses[code]), true, true})
+	}
+	for _, class := range slices.Sorted(maps.Keys(s.Errors)) {
+		ps = append(ps, point{"request_errors_total", "class", class, float64(s.Errors[class]), true, true})
+	}
+	return append(ps,
+		point{name: "request_duration_seconds_sum", v: s.Sum, counter: true},
+		point{name: "request_duration_seconds_count", v: float64(s.Count), counter: true, integer: true},
+		point{name: "requests_in_flight", v: float64(s.InFlight), integer: true},
+		point{name: "active_workers", v: float64(s.ActiveWorkers), integer: true},
+		point{name: "target_rate", v: s.TargetRate},
+	)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Pusher delivers snapshots to sinks in the background, so a slow or
+// unreachable sink never stalls the run.
+type Pusher struct {
+	sinks []Sink
+	queue chan metrics.Snapshot
+	done  chan struct{}
+
+	// Written by the background goroutine, read after done is closed.
+	pushes, failures int
+	errs             []error
+}
+
+// NewPusher starts delivering snapshots passed to Push to every sink.
+func NewPusher(sinks []Sink) *Pusher {
+	p := &Pusher{
+		sinks: sinks,
+		queue: make(chan metrics.Snapshot, 16),
+		done:  make(chan struct{}),
+		errs:  make([]error, len(sinks)),
+	}
+	go p.run()
+	return p
+}
+
+func (p *Pusher) run() {
+	defer close(p.done)
+	for s := range p.queue {
+		for i, sk := range p.sinks {
+			p.pushes++
+			if err := sk.Write(s); err != nil {
+				p.failures++
+				if p.errs[i] == nil {
+					p.errs[i] = err
+				}
+			}
+		}
+	}
+}
+
+// Push queues s for every sink. If the sinks have fallen that far
+// behind, s is dropped; the next snapshot carries the same counters.
+func (p *Pusher) Push(s metrics.Snapshot) {
+	select {
+	case p.queue <- s:
+	default:
+	}
+}
+
+// Close delivers any queued snapshots followed by last, which is never
+// dropped, closes the sinks and returns the number of writes attempted
+// and failed, along with the first error of each sink that failed.
+func (p *Pusher) Close(last metrics.Snapshot) (pushes, failures int, err error) {
+	p.queue <- last
+	close(p.queue)
+	<-p.done
+	errs := p.errs
+	for _, sk := range p.sinks {
+		errs = append(errs, sk.Close())
+	}
+	return p.pushes, p.failures, errors.Join(errs...)
+}
@@ -0,0 +1,275 @@
+package sink
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"goperf/internal/metrics"
+)
+
+func snapshot(ok uint64) metrics.Snapshot {
+	return metrics.Snapshot{
+		Time:          time.Unix(1700000000, 0),
+		Statuses:      map[int]uint64{200: ok, 503: 2},
+		Errors:        map[string]uint64{"timeout": 1},
+		Buckets:       []uint64{0, 0, 0, 1, 1, 2, 2, ok, ok, ok, ok + 3, ok + 3, ok + 3, ok + 3}, // cumulative, to +Inf
+		Sum:           1.5,
+		Count:         ok + 3,
+		InFlight:      4,
+		ActiveWorkers: 8,
+		TargetRate:    12.5,
+	}
+}
+
+func TestOpenRejectsBadURLs(t *testing.T) {
+	for _, raw := range []string{"kafka://localhost:9092", "influx://", "statsd"} {
+		if _, err := Open(raw); err == nil {
+			t.Errorf("Open(%q): expected an error", raw)
+		}
+	}
+}
+
+func TestInflux(t *testing.T) {
+	var got, path, user string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+		path = r.URL.RequestURI()
+		user, _, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, err := Open("influx://bob:pw@" + strings.TrimPrefix(srv.URL, "http://") + "?db=load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Write(snapshot(10)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if path != "/write?db=load" || user != "bob" {
+		t.Errorf("request to %s as %q", path, user)
+	}
+	for _, want := range []string{
+		"goperf_requests_total,code=200 value=10i 1700000000000000000\n",
+		"goperf_request_errors_total,class=timeout value=1i 1700000000000000000\n",
+		"goperf_request_duration_seconds_bucket,le=0.25 value=10i 1700000000000000000\n",
+		"goperf_request_duration_seconds_bucket,le=+Inf value=13i 1700000000000000000\n",
+		"goperf_request_duration_seconds_sum value=1.5 1700000000000000000\n",
+		"goperf_active_workers value=8i 1700000000000000000\n",
+		"goperf_target_rate value=12.5 1700000000000000000\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("body missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestInfluxOverTLS(t *testing.T) {
+	var scheme string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, err := Open("influxs://" + strings.TrimPrefix(srv.URL, "https://") + "?db=load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.(*influx).client = srv.Client()
+	if err := s.Write(snapshot(10)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scheme != "https" {
+		t.Error("influxs sink did not write over TLS")
+	}
+}
+
+func TestInfluxReportsRejectedWrites(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	s, _ := Open("influx://" + strings.TrimPrefix(srv.URL, "http://"))
+	defer s.Close()
+	err := s.Write(snapshot(10))
+	if err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("err = %v, want the server's message", err)
+	}
+}
+
+// TestUnreachableSinks checks that a store that is down fails writes, to
+// be counted by the Pusher, rather than Open.
+func TestUnreachableSinks(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	for _, raw := range []string{"graphite://" + addr, "statsd://unreachable.invalid:8125"} {
+		s, err := Open(raw)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", raw, err)
+		}
+		if err := s.Write(snapshot(10)); err == nil {
+			t.Errorf("%s: expected a write error", raw)
+		}
+		if err := s.Close(); err != nil {
+			t.Errorf("%s: Close: %v", raw, err)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for raw, want := range map[string]string{
+		"influx://bob:secret@db:8086/write?db=load":   "influx://bob:xxxxx@db:8086/write?db=load",
+		"influx://db:8086/write?db=load&u=bob&p=pw":   "influx://db:8086/write?db=load&p=xxxxx&u=bob",
+		"influxs://db:8086/api/v2/write?token=abc123": "influxs://db:8086/api/v2/write?token=xxxxx",
+		"statsd://localhost:8125":                     "statsd://localhost:8125",
+	} {
+		if got := Redact(raw); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestGraphite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 100)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+
+	s, err := Open("graphite://" + ln.Addr().String() + "?prefix=ci.goperf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(snapshot(10)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.Close()
+
+	var got []string
+	for l := range lines {
+		got = append(got, l)
+	}
+	all := strings.Join(got, "\n")
+	for _, want := range []string{
+		"ci.goperf.requests_total.200 10 1700000000",
+		"ci.goperf.request_errors_total.timeout 1 1700000000",
+		"ci.goperf.requests_in_flight 4 1700000000",
+		"ci.goperf.request_duration_seconds_bucket.le_0_25 10 1700000000",
+		"ci.goperf.request_duration_seconds_bucket.le_Inf 13 1700000000",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("lines missing %q:\n%s", want, all)
+		}
+	}
+}
+
+func TestStatsDSendsCounterIncreases(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := Open("statsd://" + pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	read := func() string {
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 65536)
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	if err := s.Write(snapshot(10)); err != nil {
+		t.Fatal(err)
+	}
+	first := read()
+	if err := s.Write(snapshot(25)); err != nil {
+		t.Fatal(err)
+	}
+	second := read()
+
+	for _, want := range []string{
+		"goperf.requests_total.200:10|c", "goperf.active_workers:8|g", "goperf.target_rate:12.5|g",
+		"goperf.request_duration_seconds_bucket.le_0_25:10|c", "goperf.request_duration_seconds_bucket.le_Inf:13|c",
+	} {
+		if !strings.Contains(first, want) {
+			t.Errorf("first packet missing %q:\n%s", want, first)
+		}
+	}
+	for _, want := range []string{"goperf.requests_total.200:15|c", "goperf.request_duration_seconds_bucket.le_0_25:15|c"} {
+		if !strings.Contains(second, want) {
+			t.Errorf("second packet should count the increase %q:\n%s", want, second)
+		}
+	}
+	// Unchanged counters are not resent.
+	if strings.Contains(second, "requests_total.503") || strings.Contains(second, "le_0_01:") {
+		t.Errorf("second packet resent an unchanged counter:\n%s", second)
+	}
+}
+
+type fakeSink struct {
+	writes int
+	err    error
+	closed bool
+}
+
+func (f *fakeSink) Write(metrics.Snapshot) error { f.writes++; return f.err }
+func (f *fakeSink) Close() error                 { f.closed = true; return nil }
+
+func TestPusher(t *testing.T) {
+	good, bad := &fakeSink{}, &fakeSink{err: errors.New("unreachable")}
+	p := NewPusher([]Sink{good, bad})
+	p.Push(snapshot(1))
+	p.Push(snapshot(2))
+	pushes, failures, err := p.Close(snapshot(3))
+
+	if pushes != 6 || failures != 3 {
+		t.Errorf("pushes = %d, failures = %d, want 6, 3", pushes, failures)
+	}
+	if err == nil || err.Error() != "unreachable" {
+		t.Errorf("err = %v, want the failing sink's first error", err)
+	}
+	if good.writes != 3 || !good.closed || !bad.closed {
+		t.Errorf("good sink wrote %d times; closed %v, %v", good.writes, good.closed, bad.closed)
+	}
+}
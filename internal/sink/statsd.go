@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+
+	"goperf/internal/metrics"
+)
+
+// maxPacket keeps StatsD datagrams within a typical Ethernet MTU.
+const maxPacket = 1432
+
+// statsd writes the StatsD protocol over UDP. Cumulative series are sent
+// as counters of the increase since the previous write, the rest as
+// gauges.
+type statsd struct {
+	addr   string
+	prefix string
+	conn   net.Conn // nil until dialed by a write
+	last   map[string]float64
+}
+
+func newStatsD(u *url.URL) *statsd {
+	return &statsd{addr: u.Host, prefix: prefix(u), last: make(map[string]float64)}
+}
+
+// Write sends one metric per line, batching lines into datagrams:
+//
+//	goperf.requests_total.200:312|c
+//	goperf.active_workers:8|g
+func (s *statsd) Write(snap metrics.Snapshot) error {
+	if s.conn == nil {
+		// Only resolving the host can fail; nothing is sent.
+		conn, err := net.Dial("udp", s.addr)
+		if err != nil {
+			return fmt.Errorf("statsd: %w", err)
+		}
+		s.conn = conn
+	}
+	var packet bytes.Buffer
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(bytes.TrimSuffix(packet.Bytes(), []byte("\n")))
+		packet.Reset()
+		return err
+	}
+
+	for _, p := range points(snap) {
+		name := path(s.prefix, p)
+		var line string
+		if p.counter {
+			delta := p.v - s.last[name]
+			s.last[name] = p.v
+			if delta == 0 {
+				continue
+			}
+			line = fmt.Sprintf("%s:%s|c\n", name, formatFloat(delta))
+		} else {
+			line = fmt.Sprintf("%s:%s|g\n", name, formatFloat(p.v))
+		}
+		if packet.Len()+len(line) > maxPacket {
+			if err := send(); err != nil {
+				return fmt.Errorf("statsd: %w", err)
+			}
+		}
+		packet.WriteString(line)
+	}
+	if err := send(); err != nil {
+		return fmt.Errorf("statsd: %w", err)
+	}
+	return nil
+}
+
+func (s *statsd) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,262 @@
+// Package tmpl compiles {{...}} placeholders in request URLs, headers and
+// bodies into templates that are cheap to expand on every request.
+//
+// Supported placeholders:
+//
+//	{{seq}}               request sequence number, starting at 1
+//	{{worker}}            ID of the worker sending the request
+//	{{randInt MIN MAX}}   random integer in [MIN, MAX]
+//	{{randString N}}      random alphanumeric string of length N
+//	{{uuid}}              random version 4 UUID
+//	{{timestamp}}         current time in RFC 3339 format
+//	{{unix}}              current Unix time in seconds
+//	{{unixMilli}}         current Unix time in milliseconds
+//	{{.NAME}}             value of variable NAME, such as a data file column
+package tmpl
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Context carries the per-request state placeholders are expanded from.
+// Each worker owns one Context and updates Seq and Vars before every request.
+type Context struct {
+	WorkerID int
+	Seq      uint64
+	Rand     *rand.Rand
+
+	// Vars holds variable values indexed by their Scope slot.
+	Vars []string
+}
+
+// Scope declares the variables templates may reference and assigns each a
+// slot in Context.Vars, so expansion is an index lookup rather than a map
+// access.
+type Scope struct {
+	slots map[string]int
+}
+
+// NewScope returns a scope declaring names in order, so the first name
+// occupies slot 0.
+func NewScope(names ...string) *Scope {
+	s := &Scope{slots: make(map[string]int)}
+	for _, n := range names {
+		s.Declare(n)
+	}
+	return s
+}
+
+// Declare adds name to the scope if it is not already present and returns
+// its slot.
+func (s *Scope) Declare(name string) int {
+	if i, ok := s.slots[name]; ok {
+		return i
+	}
+	s.slots[name] = len(s.slots)
+	return s.slots[name]
+}
+
+// Len returns the number of declared variables, which is the length
+// Context.Vars must have.
+func (s *Scope) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.slots)
+}
+
+// Template is a compiled string with placeholders.
+type Template struct {
+	raw   string
+	parts []part
+}
+
+// part is either a literal or a placeholder expansion.
+type part struct {
+	lit    string
+	expand func(dst []byte, c *Context) []byte
+}
+
+// Compile parses s, returning an error for unknown placeholders, malformed
+// arguments or variables not declared in scope. A nil scope declares no
+// variables.
+func Compile(s string, scope *Scope) (*Template, error) {
+	t := &Template{raw: s}
+	rest := s
+	for {
+		open := strings.Index(rest, "{{")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(rest[open:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in %q", s)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, part{lit: rest[:open]})
+		}
+		expand, err := compilePlaceholder(strings.Fields(rest[open+2:open+end]), scope)
+		if err != nil {
+			return nil, fmt.Errorf("%w in %q", err, s)
+		}
+		t.parts = append(t.parts, part{expand: expand})
+		rest = rest[open+end+2:]
+	}
+	if rest != "" {
+		t.parts = append(t.parts, part{lit: rest})
+	}
+	return t, nil
+}
+
+// Static reports whether the template contains no placeholders, in which
+// case String returns its value without any per-request work.
+func (t *Template) Static() bool {
+	return len(t.parts) == 0 || len(t.parts) == 1 && t.parts[0].expand == nil
+}
+
+// String returns the template source.
+func (t *Template) String() string {
+	return t.raw
+}
+
+// Append expands the template for c and appends the result to dst.
+func (t *Template) Append(dst []byte, c *Context) []byte {
+	for _, p := range t.parts {
+		if p.expand != nil {
+			dst = p.expand(dst, c)
+		} else {
+			dst = append(dst, p.lit...)
+		}
+	}
+	return dst
+}
+
+// Expand returns the expanded template as a string. Static templates are
+// returned without allocating.
+func (t *Template) Expand(buf []byte, c *Context) (string, []byte) {
+	if t.Static() {
+		return t.raw, buf
+	}
+	buf = t.Append(buf[:0], c)
+	return string(buf), buf
+}
+
+func compilePlaceholder(fields []string, scope *Scope) (func([]byte, *Context) []byte, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty placeholder")
+	}
+	name, args := fields[0], fields[1:]
+	if v, ok := strings.CutPrefix(name, "."); ok {
+		slot, declared := 0, false
+		if scope != nil {
+			slot, declared = scope.slots[v]
+		}
+		if !declared || len(args) != 0 {
+			return nil, fmt.Errorf("unknown variable %q", v)
+		}
+		return func(dst []byte, c *Context) []byte {
+			return append(dst, c.Vars[slot]...)
+		}, nil
+	}
+	want, ok := arity[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown placeholder %q", name)
+	}
+	if len(args) != want {
+		return nil, fmt.Errorf("placeholder %q takes %d argument(s), got %d", name, want, len(args))
+	}
+
+	switch name {
+	case "seq":
+		return func(dst []byte, c *Context) []byte {
+			return strconv.AppendUint(dst, c.Seq, 10)
+		}, nil
+	case "worker":
+		return func(dst []byte, c *Context) []byte {
+			return strconv.AppendInt(dst, int64(c.WorkerID), 10)
+		}, nil
+	case "uuid":
+		return appendUUID, nil
+	case "timestamp":
+		return func(dst []byte, _ *Context) []byte {
+			return time.Now().AppendFormat(dst, time.RFC3339)
+		}, nil
+	case "unix":
+		return func(dst []byte, _ *Context) []byte {
+			return strconv.AppendInt(dst, time.Now().Unix(), 10)
+		}, nil
+	case "unixMilli":
+		return func(dst []byte, _ *Context) []byte {
+			return strconv.AppendInt(dst, time.Now().UnixMilli(), 10)
+		}, nil
+	case "randInt":
+		lo, err1 := strconv.ParseInt(args[0], 10, 64)
+		hi, err2 := strconv.ParseInt(args[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("randInt bounds must be integers, got %q and %q", args[0], args[1])
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("randInt max %d is less than min %d", hi, lo)
+		}
+		span := uint64(hi-lo) + 1
+		return func(dst []byte, c *Context) []byte {
+			var n uint64
+			if span == 0 { // full int64 range
+				n = c.Rand.Uint64()
+			} else {
+				n = c.Rand.Uint64N(span)
+			}
+			return strconv.AppendInt(dst, lo+int64(n), 10)
+		}, nil
+	case "randString":
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("randString length must be a positive integer, got %q", args[0])
+		}
+		return func(dst []byte, c *Context) []byte {
+			for range n {
+				dst = append(dst, alphanumeric[c.Rand.IntN(len(alphanumeric))])
+			}
+			return dst
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown placeholder %q", name)
+}
+
+// arity maps each placeholder name to its number of arguments.
+var arity = map[string]int{
+	"seq":        0,
+	"worker":     0,
+	"uuid":       0,
+	"timestamp":  0,
+	"unix":       0,
+	"unixMilli":  0,
+	"randInt":    2,
+	"randString": 1,
+}
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+const hexDigits = "0123456789abcdef"
+
+func appendUUID(dst []byte, c *Context) []byte {
+	var b [16]byte
+	hi, lo := c.Rand.Uint64(), c.Rand.Uint64()
+	for i := range 8 {
+		b[i] = byte(hi >> (56 - 8*i))
+		b[8+i] = byte(lo >> (56 - 8*i))
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	for i, v := range b {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			dst = append(dst, '-')
+		}
+		dst = append(dst, hexDigits[v>>4], hexDigits[v&0x0f])
+	}
+	return dst
+}
@@ -0,0 +1,140 @@
+package tmpl
+
+import (
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newContext() *Context {
+	return &Context{WorkerID: 3, Seq: 42, Rand: rand.New(rand.NewPCG(1, 2))}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		match string
+	}{
+		{name: "literal", src: "http://example.com/a", match: `^http://example\.com/a$`},
+		{name: "seq and worker", src: "/items/{{seq}}?w={{worker}}", match: `^/items/42\?w=3$`},
+		{name: "randInt", src: "{{randInt 5 7}}", match: `^[5-7]$`},
+		{name: "negative randInt", src: "{{randInt -2 -1}}", match: `^-[12]$`},
+		{name: "randString", src: "{{randString 12}}", match: `^[a-zA-Z0-9]{12}$`},
+		{name: "uuid", src: "{{ uuid }}", match: `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{name: "timestamp", src: "{{timestamp}}", match: `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}`},
+		{name: "unix", src: "{{unix}}", match: `^\d{10}$`},
+		{name: "unixMilli", src: "{{unixMilli}}", match: `^\d{13}$`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl, err := Compile(tt.src, nil)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.src, err)
+			}
+			got, _ := tpl.Expand(nil, newContext())
+			if !regexp.MustCompile(tt.match).MatchString(got) {
+				t.Errorf("Expand(%q) = %q, want match for %s", tt.src, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"{{seq",
+		"{{}}",
+		"{{nope}}",
+		"{{seq 1}}",
+		"{{randInt 1}}",
+		"{{randInt a b}}",
+		"{{randInt 9 1}}",
+		"{{randString 0}}",
+	} {
+		if _, err := Compile(src, nil); err == nil {
+			t.Errorf("Compile(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	for src, want := range map[string]bool{
+		"":                true,
+		"plain":           true,
+		"a{{seq}}":        false,
+		"{{uuid}}{{seq}}": false,
+	} {
+		tpl, err := Compile(src, nil)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", src, err)
+		}
+		if got := tpl.Static(); got != want {
+			t.Errorf("Static(%q) = %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestRandIntCoversRange(t *testing.T) {
+	tpl, err := Compile("{{randInt 1 3}}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newContext()
+	seen := map[int]bool{}
+	var buf []byte
+	for range 200 {
+		buf = tpl.Append(buf[:0], c)
+		n, _ := strconv.Atoi(string(buf))
+		seen[n] = true
+	}
+	if len(seen) != 3 || !seen[1] || !seen[3] {
+		t.Errorf("randInt 1 3 produced %v, want all of 1..3", seen)
+	}
+}
+
+func TestAppendAllocations(t *testing.T) {
+	tpl, err := Compile("/users/{{randInt 1 1000}}/orders/{{seq}}?w={{worker}}&id={{uuid}}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newContext()
+	buf := make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = tpl.Append(buf[:0], c)
+	})
+	if allocs != 0 {
+		t.Errorf("Append allocated %v times per run, want 0", allocs)
+	}
+	if !strings.HasPrefix(string(buf), "/users/") {
+		t.Errorf("unexpected expansion %q", buf)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	scope := NewScope("id", "token")
+	if got := scope.Declare("token"); got != 1 {
+		t.Errorf("Declare(existing) = %d, want 1", got)
+	}
+
+	tpl, err := Compile("/users/{{.id}}?t={{.token}}&s={{seq}}", scope)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	c := newContext()
+	c.Vars = []string{"17", "abc"}
+	if got, _ := tpl.Expand(nil, c); got != "/users/17?t=abc&s=42" {
+		t.Errorf("Expand = %q", got)
+	}
+
+	for _, src := range []string{"{{.missing}}", "{{.id extra}}"} {
+		if _, err := Compile(src, scope); err == nil {
+			t.Errorf("Compile(%q) succeeded, want error", src)
+		}
+	}
+	if _, err := Compile("{{.id}}", nil); err == nil {
+		t.Error("Compile with nil scope accepted a variable")
+	}
+}
@@ -0,0 +1,229 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// bufferSize is the number of spans that may wait to be exported.
+	bufferSize = 1 << 16
+	// batchSize and flushInterval bound how many spans are sent per
+	// request and how long a span waits before it is sent.
+	batchSize     = 512
+	flushInterval = time.Second
+	// timeout bounds each export request.
+	timeout = 5 * time.Second
+)
+
+// Span is a completed client request.
+type Span struct {
+	Context
+	Name   string // the step name in a flow, otherwise the method
+	Start  time.Time
+	End    time.Time
+	Worker int
+	Method string
+	URL    string
+	Status int
+	Error  string
+}
+
+// Exporter sends spans to an OTLP/HTTP collector as JSON in batches from
+// a background goroutine. When its buffer is full, spans are dropped and
+// counted. A failed export drops its batch rather than retrying.
+type Exporter struct {
+	endpoint string
+	client   *http.Client
+	spans    chan Span
+	done     chan struct{}
+
+	dropped int // by Export
+
+	// Written by the background goroutine, read after done is closed.
+	exported, failed int
+	err              error
+}
+
+// NewExporter starts exporting spans to endpoint, the collector's traces
+// URL such as http://localhost:4318/v1/traces.
+func NewExporter(endpoint string) *Exporter {
+	e := &Exporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+		spans:    make(chan Span, bufferSize),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues s, dropping it if the exporter has fallen behind. It
+// must be called from a single goroutine.
+func (e *Exporter) Export(s Span) {
+	select {
+	case e.spans <- s:
+	default:
+		e.dropped++
+	}
+}
+
+// Close exports the spans still queued and returns how many spans were
+// exported and dropped, along with the first export error.
+func (e *Exporter) Close() (exported, dropped int, err error) {
+	close(e.spans)
+	<-e.done
+	e.client.CloseIdleConnections()
+	return e.exported, e.dropped + e.failed, e.err
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.post(batch); err != nil {
+			e.failed += len(batch)
+			if e.err == nil {
+				e.err = err
+			}
+		} else {
+			e.exported += len(batch)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// post sends one batch in the OTLP/HTTP JSON encoding.
+func (e *Exporter) post(batch []Span) error {
+	body, err := json.Marshal(encode(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("otlp: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("otlp: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// The subset of the OTLP trace request used to export client spans.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []attribute `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID    string      `json:"traceId"`
+		SpanID     string      `json:"spanId"`
+		Name       string      `json:"name"`
+		Kind       int         `json:"kind"`
+		Start      string      `json:"startTimeUnixNano"`
+		End        string      `json:"endTimeUnixNano"`
+		Attributes []attribute `json:"attributes"`
+		Status     status      `json:"status"`
+	}
+	attribute struct {
+		Key   string `json:"key"`
+		Value value  `json:"value"`
+	}
+	value struct {
+		String string `json:"stringValue,omitempty"`
+		Int    string `json:"intValue,omitempty"`
+	}
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const (
+	spanKindClient  = 3
+	statusCodeError = 2
+)
+
+func encode(batch []Span) exportRequest {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		o := otlpSpan{
+			TraceID: s.Trace(),
+			SpanID:  s.Span(),
+			Name:    s.Name,
+			Kind:    spanKindClient,
+			Start:   strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes: []attribute{
+				stringAttr("http.request.method", s.Method),
+				stringAttr("url.full", s.URL),
+				intAttr("goperf.worker", s.Worker),
+			},
+		}
+		// Client spans are errors for any failure, including 4xx.
+		switch {
+		case s.Error != "":
+			o.Status = status{Code: statusCodeError, Message: s.Error}
+		case s.Status >= 400:
+			o.Status = status{Code: statusCodeError}
+		}
+		if s.Status > 0 {
+			o.Attributes = append(o.Attributes, intAttr("http.response.status_code", s.Status))
+		}
+		spans[i] = o
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []attribute{stringAttr("service.name", "goperf")}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "goperf"}, Spans: spans}},
+	}}}
+}
+
+func stringAttr(k, v string) attribute {
+	return attribute{Key: k, Value: value{String: v}}
+}
+
+func intAttr(k string, v int) attribute {
+	return attribute{Key: k, Value: value{Int: strconv.Itoa(v)}}
+}
@@ -0,0 +1,53 @@
+// Package trace propagates W3C Trace Context on outbound requests and
+// exports the matching client spans to an OpenTelemetry collector, so a
+// slow goperf request can be found among the server's traces.
+package trace
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand/v2"
+)
+
+// Context identifies one request's span within its trace.
+type Context struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// New returns a Context with random, valid trace and span IDs. IDs come
+// from the unseeded generator so runs with the same -seed still get
+// distinct traces.
+func New() Context {
+	var c Context
+	for c.TraceID == ([16]byte{}) {
+		binary.BigEndian.PutUint64(c.TraceID[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(c.TraceID[8:], rand.Uint64())
+	}
+	for c.SpanID == ([8]byte{}) {
+		binary.BigEndian.PutUint64(c.SpanID[:], rand.Uint64())
+	}
+	return c
+}
+
+// IsValid reports whether c holds a trace, which it does not when
+// tracing is off.
+func (c Context) IsValid() bool {
+	return c.TraceID != [16]byte{}
+}
+
+// Trace returns the trace ID in hex, as shown by tracing backends.
+func (c Context) Trace() string {
+	return hex.EncodeToString(c.TraceID[:])
+}
+
+// Span returns the span ID in hex.
+func (c Context) Span() string {
+	return hex.EncodeToString(c.SpanID[:])
+}
+
+// Traceparent returns the value of the traceparent header for c, marked
+// as sampled so the server records its side of the trace.
+func (c Context) Traceparent() string {
+	return "00-" + c.Trace() + "-" + c.Span() + "-01"
+}
@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTraceparent(t *testing.T) {
+	c := New()
+	if !c.IsValid() {
+		t.Fatal("new context is not valid")
+	}
+	if (Context{}).IsValid() {
+		t.Error("zero context is valid")
+	}
+	re := regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`)
+	if tp := c.Traceparent(); !re.MatchString(tp) {
+		t.Errorf("traceparent %q is malformed", tp)
+	}
+	if New().TraceID == c.TraceID {
+		t.Error("two new contexts share a trace ID")
+	}
+}
+
+func TestExporter(t *testing.T) {
+	var mu sync.Mutex
+	var got []exportRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request to %s with %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req exportRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("body is not JSON: %v", err)
+		}
+		mu.Lock()
+		got = append(got, req)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	start := time.Unix(1700000000, 0)
+	ok := Span{Context: New(), Name: "GET", Start: start, End: start.Add(time.Millisecond), Method: "GET", URL: "http://x/a", Status: 200}
+	failed := Span{Context: New(), Name: "login", Start: start, End: start.Add(time.Second), Method: "POST", URL: "http://x/login", Error: "timeout"}
+
+	e := NewExporter(srv.URL + "/v1/traces")
+	e.Export(ok)
+	e.Export(failed)
+	exported, dropped, err := e.Close()
+	if err != nil || exported != 2 || dropped != 0 {
+		t.Fatalf("exported %d, dropped %d, err %v", exported, dropped, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 {
+		t.Fatalf("got %d export requests, want 1", len(got))
+	}
+	rs := got[0].ResourceSpans[0]
+	if rs.Resource.Attributes[0] != stringAttr("service.name", "goperf") {
+		t.Errorf("resource = %+v", rs.Resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	s := spans[0]
+	if s.TraceID != ok.Trace() || s.SpanID != ok.Span() || s.Kind != spanKindClient ||
+		s.Start != "1700000000000000000" || s.End != "1700000000001000000" || s.Status.Code != 0 {
+		t.Errorf("span = %+v", s)
+	}
+	if s.Attributes[len(s.Attributes)-1] != intAttr("http.response.status_code", 200) {
+		t.Errorf("attributes = %+v", s.Attributes)
+	}
+	if f := spans[1]; f.Name != "login" || f.Status.Code != statusCodeError || f.Status.Message != "timeout" {
+		t.Errorf("failed span = %+v", f)
+	}
+}
+
+func TestExporterCountsFailedBatches(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	e := NewExporter(srv.URL)
+	for range 3 {
+		e.Export(Span{Context: New()})
+	}
+	exported, dropped, err := e.Close()
+	if exported != 0 || dropped != 3 {
+		t.Errorf("exported %d, dropped %d, want 0, 3", exported, dropped)
+	}
+	if err == nil || !strings.Contains(err.Error(), "overloaded") {
+		t.Errorf("err = %v, want the collector's error", err)
+	}
+}
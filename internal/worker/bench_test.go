@@ -23,10 +23,12 @@ func BenchmarkRun(b *testing.B) {
 		}
 	}()
 
+	w := newWorker(b, client, "GET", srv.URL)
+
 	b.ResetTimer()
 	for b.Loop() {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-		Run(ctx, client, "GET", srv.URL, results)
+		w.Run(ctx, results)
 		cancel()
 	}
 	b.StopTimer()
@@ -49,10 +51,42 @@ func BenchmarkRunLargeResponse(b *testing.B) {
 		}
 	}()
 
+	w := newWorker(b, client, "GET", srv.URL)
+
+	b.ResetTimer()
+	for b.Loop() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		w.Run(ctx, results)
+		cancel()
+	}
+	b.StopTimer()
+	close(results)
+}
+
+func BenchmarkRunTemplated(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	header := http.Header{"X-Request-Id": {"{{uuid}}"}}
+	req, err := NewRequest("POST", srv.URL+"/users/{{randInt 1 1000}}?seq={{seq}}", header, `{"worker":{{worker}}}`, nil)
+	if err != nil {
+		b.Fatalf("NewRequest: %v", err)
+	}
+	w := &Worker{Client: srv.Client(), Steps: []Step{{Request: req}}}
+	results := make(chan Result, 1000)
+
+	go func() {
+		for range results {
+		}
+	}()
+
+	b.ReportAllocs()
 	b.ResetTimer()
 	for b.Loop() {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-		Run(ctx, client, "GET", srv.URL, results)
+		w.Run(ctx, results)
 		cancel()
 	}
 	b.StopTimer()
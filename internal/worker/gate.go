@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// Gate limits how many of a run's workers are active. Workers whose ID is
+// at or above the limit wait before each iteration until it is raised.
+type Gate struct {
+	mu      sync.Mutex
+	limit   int
+	changed chan struct{}
+}
+
+// NewGate returns a gate admitting workers with IDs below limit.
+func NewGate(limit int) *Gate {
+	return &Gate{limit: limit, changed: make(chan struct{})}
+}
+
+// Limit returns the number of workers currently admitted.
+func (g *Gate) Limit() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.limit
+}
+
+// SetLimit changes the number of workers admitted, waking any waiting
+// workers so they can check it again.
+func (g *Gate) SetLimit(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if n == g.limit {
+		return
+	}
+	g.limit = n
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// Wait blocks until worker id is admitted, returning false if ctx is done
+// first.
+func (g *Gate) Wait(ctx context.Context, id int) bool {
+	for {
+		g.mu.Lock()
+		ok, changed := id < g.limit, g.changed
+		g.mu.Unlock()
+		if ok {
+			return ctx.Err() == nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
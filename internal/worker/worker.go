@@ -2,10 +2,25 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"goperf/internal/dist"
+	"goperf/internal/feeder"
+	"goperf/internal/flow"
+	"goperf/internal/tmpl"
+	"goperf/internal/trace"
 )
 
 // Result holds the outcome of a single HTTP request.
@@ -13,68 +28,531 @@ type Result struct {
 	Duration   time.Duration
 	StatusCode int
 	Error      error
+
+	// Step is the index of the flow step the request belongs to.
+	Step int
+	// Transaction marks a result that covers a whole flow iteration rather
+	// than a single request. Its Step is the last step reached and its
+	// StatusCode and Error are those of that step.
+	Transaction bool
+
+	// Think is the think time that follows the request and Pacing the
+	// wait before the next iteration; neither is part of Duration.
+	// Overran is set on the last request of an iteration that took
+	// longer than the pacing interval.
+	Think   time.Duration
+	Pacing  time.Duration
+	Overran bool
+
+	// ProxyConnect is the time spent establishing an HTTP CONNECT tunnel
+	// through a proxy. It is zero unless the request opened a new tunnel.
+	ProxyConnect time.Duration
+
+	// Detail describes the request for the request log and report. It is
+	// only recorded when Worker.Detail is set, and never for transactions.
+	Detail *Detail
+
+	// Trace identifies the request's span when Worker.Trace is set.
+	Trace trace.Context
+}
+
+// Detail describes a single request. Phase timings are zero when the
+// phase did not happen, for example DNS, Connect and TLS on a reused
+// connection.
+type Detail struct {
+	Start  time.Time
+	Worker int
+	Method string
+	// URL is the expanded request URL with any password redacted, as it
+	// ends up in logs, reports and spans.
+	URL string
+	// Bytes is the size of the response body.
+	Bytes  int64
+	Reused bool
+
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is the time from sending the request to the first response
+	// byte.
+	TTFB time.Duration
+}
+
+// connState records connection-setup timings for one worker. Dials may
+// complete on transport goroutines, so the fields are accessed atomically.
+type connState struct {
+	ready  atomic.Int64 // monotonic nanoseconds when the proxy connection was last usable
+	tunnel atomic.Int64 // duration of the most recent CONNECT exchange
+
+	// Phase timestamps of the current request in monotonic nanoseconds,
+	// zero until the phase is reached.
+	dnsStart, dnsDone   atomic.Int64
+	connStart, connDone atomic.Int64
+	tlsStart, tlsDone   atomic.Int64
+	firstByte           atomic.Int64
+	reused              atomic.Bool
+}
+
+// resetPhases clears the phase timestamps before a request.
+func (st *connState) resetPhases() {
+	for _, v := range []*atomic.Int64{&st.dnsStart, &st.dnsDone, &st.connStart, &st.connDone, &st.tlsStart, &st.tlsDone, &st.firstByte} {
+		v.Store(0)
+	}
+	st.reused.Store(false)
+}
+
+// phases fills in the phase timings of d, a request sent at start (in
+// monotonic nanoseconds).
+func (st *connState) phases(d *Detail, start int64) {
+	span := func(from, to *atomic.Int64) time.Duration {
+		a, b := from.Load(), to.Load()
+		if a == 0 || b < a {
+			return 0
+		}
+		return time.Duration(b - a)
+	}
+	d.DNS = span(&st.dnsStart, &st.dnsDone)
+	d.Connect = span(&st.connStart, &st.connDone)
+	d.TLS = span(&st.tlsStart, &st.tlsDone)
+	if fb := st.firstByte.Load(); fb > start {
+		d.TTFB = time.Duration(fb - start)
+	}
+	d.Reused = st.reused.Load()
+}
+
+// monotonic returns the time since epoch in nanoseconds.
+func monotonic() int64 {
+	return int64(time.Since(epoch))
+}
+
+type connStateKey struct{}
+
+// epoch anchors the monotonic timestamps stored in connState.
+var epoch = time.Now()
+
+// ObserveProxyConnect is an http.Transport OnProxyConnectResponse hook that
+// attributes the CONNECT round trip to the worker whose request triggered
+// the dial. Install it on any transport shared by workers.
+func ObserveProxyConnect(ctx context.Context, _ *url.URL, _ *http.Request, _ *http.Response) error {
+	if st, ok := ctx.Value(connStateKey{}).(*connState); ok {
+		if ready := st.ready.Load(); ready != 0 {
+			st.tunnel.Store(monotonic() - ready)
+		}
+	}
+	return nil
+}
+
+// withConnState attaches a per-worker connState and the client trace that
+// feeds it. It is done once per worker rather than per request so the
+// request loop stays allocation-free.
+func withConnState(ctx context.Context) (context.Context, *connState) {
+	st := &connState{}
+	ctx = context.WithValue(ctx, connStateKey{}, st)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { st.dnsStart.Store(monotonic()) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { st.dnsDone.Store(monotonic()) },
+		ConnectStart: func(string, string) { st.connStart.Store(monotonic()) },
+		ConnectDone: func(string, string, error) {
+			now := monotonic()
+			st.connDone.Store(now)
+			st.ready.Store(now)
+		},
+		TLSHandshakeStart: func() { st.tlsStart.Store(monotonic()) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			now := monotonic()
+			st.tlsDone.Store(now)
+			st.ready.Store(now)
+		},
+		GotConn:              func(info httptrace.GotConnInfo) { st.reused.Store(info.Reused) },
+		GotFirstResponseByte: func() { st.firstByte.Store(monotonic()) },
+	})
+	return ctx, st
+}
+
+// Request describes the HTTP request a worker sends on every iteration.
+// It is compiled once per run and shared read-only by all workers.
+type Request struct {
+	Method string
+	URL    *tmpl.Template
+	Header []Header
+	Body   *tmpl.Template // nil when the request has no body
+
+	// Scope declares the template variables; data columns occupy its
+	// first slots in column order.
+	Scope *tmpl.Scope
+}
+
+// Header is a request header whose value may contain placeholders.
+type Header struct {
+	Key   string // canonical form
+	Value *tmpl.Template
+
+	static []string // shared value slice when Value has no placeholders
+}
+
+// NewRequest compiles the URL, header values and body of a request, which
+// may reference the variables declared in scope. An empty body means no
+// body is sent.
+func NewRequest(method, rawURL string, header http.Header, body string, scope *tmpl.Scope) (*Request, error) {
+	u, err := tmpl.Compile(rawURL, scope)
+	if err != nil {
+		return nil, fmt.Errorf("url: %w", err)
+	}
+	r := &Request{Method: method, URL: u, Scope: scope}
+	for key, values := range header {
+		for _, v := range values {
+			t, err := tmpl.Compile(v, scope)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", key, err)
+			}
+			h := Header{Key: http.CanonicalHeaderKey(key), Value: t}
+			if t.Static() {
+				h.static = []string{t.String()}
+			}
+			r.Header = append(r.Header, h)
+		}
+	}
+	if body != "" {
+		if r.Body, err = tmpl.Compile(body, scope); err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Step is one request of a flow together with the values to extract from
+// its response for later steps.
+type Step struct {
+	Name    string
+	Request *Request
+	Extract []*flow.Extractor
+}
+
+// needsBody reports whether any extraction reads the response body.
+func (s *Step) needsBody() bool {
+	for _, e := range s.Extract {
+		if e.NeedsBody() {
+			return true
+		}
+	}
+	return false
+}
+
+// Worker is a single virtual user sending requests in a loop.
+type Worker struct {
+	ID     int
+	Client *http.Client
+
+	// Steps are sent in order on every iteration, stopping at the first
+	// failure. With more than one step each iteration is also reported
+	// as a transaction. All steps share one template scope.
+	Steps []Step
+
+	// Seq numbers requests across all workers of a run for {{seq}}.
+	// A nil Seq numbers this worker's requests on their own.
+	Seq *atomic.Uint64
+
+	// Data supplies a row of template variables for each iteration. The
+	// worker stops when it runs out of rows. Nil means no data file.
+	Data *feeder.Cursor
+
+	// Cookies gives the worker a cookie jar of its own on top of Client,
+	// so it keeps a session like a real user. SessionReset, when
+	// positive, replaces the jar every that many iterations.
+	Cookies      bool
+	SessionReset int
+
+	// Think, if set, is the pause after each request, like a user reading
+	// a page. Pacing, if positive, starts an iteration at most once per
+	// interval, waiting out the remainder after each iteration in place of
+	// the final think time. An iteration that overruns the interval is
+	// followed immediately by the next.
+	Think  dist.Dist
+	Pacing time.Duration
+
+	// Tickets, if set, gates iterations: the worker takes one token before
+	// each iteration, letting a scheduler control the arrival rate.
+	Tickets <-chan struct{}
+
+	// Detail records a Detail for every request, for the request log and report.
+	Detail bool
+
+	// Trace sends a W3C traceparent header with a new trace on every
+	// request, recorded in the result, so the request can be found in the
+	// target's traces.
+	Trace bool
+
+	// InFlight, if set, is incremented while the worker waits for a
+	// response, giving a live count of requests in flight across workers.
+	InFlight *atomic.Int64
+
+	// Gate, if set, holds the worker before each iteration while its ID
+	// is at or above the gate's limit, letting a controller vary the
+	// number of active workers.
+	Gate *Gate
+
+	// Rand is the worker's source of randomness for templates, think time
+	// and data. Nil seeds one at random.
+	Rand *rand.Rand
 }
 
 // Run sends HTTP requests in a loop until the context is cancelled.
 // Each result is sent to the results channel.
-func Run(ctx context.Context, client *http.Client, method, url string, results chan<- Result) {
-	for {
+func (w *Worker) Run(ctx context.Context, results chan<- Result) {
+	ctx, st := withConnState(ctx)
+	seq := w.Seq
+	if seq == nil {
+		seq = new(atomic.Uint64)
+	}
+	rng := w.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), uint64(w.ID)))
+	}
+	tc := &tmpl.Context{
+		WorkerID: w.ID,
+		Rand:     rng,
+		Vars:     make([]string, w.Steps[0].Request.Scope.Len()),
+	}
+	var buf []byte
+
+	// Reused for think time and pacing so pauses do not allocate.
+	timer := time.NewTimer(0)
+	timer.Stop()
+
+	client := w.Client
+	if w.Cookies {
+		c := *w.Client
+		client = &c
+	}
+
+	// send delivers r unless the context is done first, in which case the
+	// result is dropped on a best-effort basis and the worker should stop.
+	send := func(r Result) bool {
+		select {
+		case results <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for iter := 0; ; iter++ {
 		select {
 		case <-ctx.Done():
 			return
 		default:
 		}
 
-		start := time.Now()
-		req, err := http.NewRequestWithContext(ctx, method, url, nil)
-		if err != nil {
-			if ctx.Err() != nil {
-				return
-			}
+		if w.Gate != nil && !w.Gate.Wait(ctx, w.ID) {
+			return
+		}
+
+		if w.Tickets != nil {
 			select {
-			case results <- Result{
-				Duration: time.Since(start),
-				Error:    err,
-			}:
+			case <-w.Tickets:
 			case <-ctx.Done():
 				return
 			}
-			continue
 		}
 
-		resp, err := client.Do(req)
-		elapsed := time.Since(start)
+		if w.Cookies && (iter == 0 || w.SessionReset > 0 && iter%w.SessionReset == 0) {
+			// cookiejar.New only fails for invalid options.
+			client.Jar, _ = cookiejar.New(nil)
+		}
 
-		if err != nil {
+		// Variables extracted in the previous iteration do not carry over.
+		clear(tc.Vars)
+		if w.Data != nil {
+			row, ok := w.Data.Next(tc.Rand)
+			if !ok {
+				return
+			}
+			copy(tc.Vars, row)
+		}
+
+		start := time.Now()
+		var last Result
+		var thought time.Duration // think time inside this iteration
+		for i := range w.Steps {
+			tc.Seq = seq.Add(1)
+			last, buf = w.step(ctx, client, st, i, tc, buf)
+			final := i == len(w.Steps)-1 || last.Error != nil || last.StatusCode >= 400
+			if w.Think != nil && !(final && w.Pacing > 0) {
+				last.Think = w.Think.Sample(tc.Rand)
+			}
+			if final && w.Pacing > 0 {
+				last.Pacing = w.Pacing - time.Since(start)
+				if last.Pacing <= 0 {
+					last.Pacing, last.Overran = 0, true
+				}
+			}
 			// Record the error even if context was cancelled mid-request.
 			// This ensures in-flight requests at shutdown are counted.
-			select {
-			case results <- Result{
-				Duration: elapsed,
-				Error:    err,
-			}:
-			case <-ctx.Done():
-				// Channel full and context done — best-effort, drop it.
+			if !send(last) {
+				return
+			}
+			if last.Error != nil && ctx.Err() != nil {
 				return
 			}
-			if ctx.Err() != nil {
+			if final {
+				break
+			}
+			thought += last.Think
+			if !sleep(ctx, timer, last.Think) {
 				return
 			}
-			continue
 		}
 
-		func() {
-			defer resp.Body.Close()
-			io.Copy(io.Discard, resp.Body)
-		}()
+		if len(w.Steps) > 1 {
+			if !send(Result{
+				Transaction: true,
+				Step:        last.Step,
+				Duration:    time.Since(start) - thought,
+				StatusCode:  last.StatusCode,
+				Error:       last.Error,
+			}) {
+				return
+			}
+		}
 
-		select {
-		case results <- Result{
-			Duration:   elapsed,
-			StatusCode: resp.StatusCode,
-		}:
-		case <-ctx.Done():
+		if !sleep(ctx, timer, last.Think+last.Pacing) {
 			return
 		}
 	}
 }
+
+// sleep pauses for d, returning false if the context is done first.
+func sleep(ctx context.Context, timer *time.Timer, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer.Reset(d)
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	}
+}
+
+// step sends request i of the flow and stores any extracted values in tc.
+// buf is scratch space reused across calls and is returned, possibly grown.
+func (w *Worker) step(ctx context.Context, client *http.Client, st *connState, i int, tc *tmpl.Context, buf []byte) (Result, []byte) {
+	s := &w.Steps[i]
+	req, buf, err := build(ctx, s.Request, tc, buf)
+	if err != nil {
+		return Result{Step: i, Error: err}, buf
+	}
+
+	var tr trace.Context
+	if w.Trace {
+		tr = trace.New()
+		req.Header.Set("traceparent", tr.Traceparent())
+	}
+	if w.Detail {
+		st.resetPhases()
+	}
+	if w.InFlight != nil {
+		w.InFlight.Add(1)
+		defer w.InFlight.Add(-1)
+	}
+	start := time.Now()
+	st.tunnel.Store(0)
+	resp, err := client.Do(req)
+	r := Result{
+		Step:         i,
+		Duration:     time.Since(start),
+		ProxyConnect: time.Duration(st.tunnel.Swap(0)),
+		Trace:        tr,
+	}
+	if w.Detail {
+		r.Detail = &Detail{Start: start, Worker: w.ID, Method: req.Method, URL: req.URL.Redacted()}
+		st.phases(r.Detail, int64(start.Sub(epoch)))
+	}
+	if err != nil {
+		r.Error = err
+		return r, buf
+	}
+	defer resp.Body.Close()
+	r.StatusCode = resp.StatusCode
+
+	// The expanded URL and headers have been copied into req, so buf can
+	// hold the body.
+	var body []byte
+	if resp.StatusCode < 400 && s.needsBody() {
+		b := bytes.NewBuffer(buf[:0])
+		n, err := b.ReadFrom(resp.Body)
+		buf, body = b.Bytes(), b.Bytes()
+		if r.Detail != nil {
+			r.Detail.Bytes = n
+		}
+		if err != nil {
+			r.Error = err
+			return r, buf
+		}
+	} else {
+		n, _ := io.Copy(io.Discard, resp.Body)
+		if r.Detail != nil {
+			r.Detail.Bytes = n
+		}
+	}
+
+	if resp.StatusCode < 400 {
+		r.Error = extract(s, resp, body, tc)
+	}
+	return r, buf
+}
+
+// extract applies the step's extractions, stopping at the first that fails.
+func extract(s *Step, resp *http.Response, body []byte, tc *tmpl.Context) error {
+	for _, e := range s.Extract {
+		v, err := e.Extract(resp, body)
+		if err != nil {
+			return fmt.Errorf("extract %s: %w", e.Var, err)
+		}
+		tc.Vars[e.Slot] = v
+	}
+	return nil
+}
+
+// build expands the request templates for the current iteration. buf is
+// scratch space reused across calls and is returned, possibly grown.
+func build(ctx context.Context, r *Request, tc *tmpl.Context, buf []byte) (*http.Request, []byte, error) {
+	var rawURL string
+	rawURL, buf = r.URL.Expand(buf, tc)
+
+	var body io.Reader
+	if r.Body != nil {
+		// The transport may still be reading a body after Do returns, so
+		// templated bodies get their own buffer rather than reusing buf.
+		if r.Body.Static() {
+			body = strings.NewReader(r.Body.String())
+		} else {
+			body = bytes.NewReader(r.Body.Append(nil, tc))
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, rawURL, body)
+	if err != nil {
+		return nil, buf, err
+	}
+	for _, h := range r.Header {
+		v := h.static
+		if v == nil {
+			var s string
+			s, buf = h.Value.Expand(buf, tc)
+			v = []string{s}
+		}
+		switch prev := req.Header[h.Key]; {
+		case h.Key == "Host":
+			req.Host = v[0]
+		case prev == nil:
+			req.Header[h.Key] = v
+		default:
+			// Cap prev so a shared static slice is never appended to in place.
+			req.Header[h.Key] = append(prev[:len(prev):len(prev)], v...)
+		}
+	}
+	return req, buf, nil
+}
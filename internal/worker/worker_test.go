@@ -2,12 +2,31 @@ package worker
 
 import (
 	"context"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"goperf/internal/dist"
+	"goperf/internal/flow"
+	"goperf/internal/tmpl"
 )
 
+func newWorker(tb testing.TB, client *http.Client, method, url string) *Worker {
+	tb.Helper()
+	req, err := NewRequest(method, url, nil, "", nil)
+	if err != nil {
+		tb.Fatalf("NewRequest: %v", err)
+	}
+	return &Worker{Client: client, Steps: []Step{{Request: req}}}
+}
+
 func TestRunSendsResults(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -30,7 +49,7 @@ func TestRunSendsResults(t *testing.T) {
 		close(done)
 	}()
 
-	Run(ctx, client, "GET", srv.URL, results)
+	newWorker(t, client, "GET", srv.URL).Run(ctx, results)
 	close(results)
 	<-done
 
@@ -68,9 +87,10 @@ func TestRunStopsOnCancel(t *testing.T) {
 	results := make(chan Result, 1000)
 	client := srv.Client()
 
+	w := newWorker(t, client, "GET", srv.URL)
 	doneCh := make(chan struct{})
 	go func() {
-		Run(ctx, client, "GET", srv.URL, results)
+		w.Run(ctx, results)
 		close(doneCh)
 	}()
 
@@ -106,7 +126,7 @@ func TestRunRecordsErrorStatus(t *testing.T) {
 		close(done)
 	}()
 
-	Run(ctx, client, "GET", srv.URL, results)
+	newWorker(t, client, "GET", srv.URL).Run(ctx, results)
 	close(results)
 	<-done
 
@@ -129,3 +149,498 @@ func TestRunRecordsErrorStatus(t *testing.T) {
 		t.Fatal("expected at least one 500 response")
 	}
 }
+
+// connectProxy returns a proxy server that tunnels CONNECT requests to
+// their target and counts how many tunnels it opened.
+func connectProxy(t *testing.T, tunnels *atomic.Int64) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		tunnels.Add(1)
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+}
+
+func TestRunRecordsProxyConnect(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	var tunnels atomic.Int64
+	proxy := connectProxy(t, &tunnels)
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	transport := target.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	transport.OnProxyConnectResponse = ObserveProxyConnect
+	client := &http.Client{Transport: transport}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	results := make(chan Result, 1000)
+	newWorker(t, client, "GET", target.URL).Run(ctx, results)
+	close(results)
+
+	var withTunnel, succeeded int
+	for r := range results {
+		if r.ProxyConnect > 0 {
+			withTunnel++
+		}
+		if r.Error == nil && r.StatusCode == http.StatusOK {
+			succeeded++
+		}
+	}
+
+	if succeeded == 0 {
+		t.Fatal("expected at least one successful request through the proxy")
+	}
+	if tunnels.Load() == 0 {
+		t.Fatal("proxy never received a CONNECT request")
+	}
+	// A dial started just before cancellation can finish after its request
+	// was abandoned, so allow fewer timed results than tunnels.
+	if withTunnel == 0 || int64(withTunnel) > tunnels.Load() {
+		t.Errorf("results with CONNECT timing = %d, want between 1 and %d", withTunnel, tunnels.Load())
+	}
+}
+
+func TestRunExpandsTemplates(t *testing.T) {
+	type seen struct {
+		path, header, body string
+	}
+	got := make(chan seen, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		select {
+		case got <- seen{r.URL.Path, r.Header.Get("X-Request-Id"), string(body)}:
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	header := http.Header{"X-Request-Id": {"w{{worker}}-{{seq}}"}}
+	req, err := NewRequest("POST", srv.URL+"/items/{{seq}}", header, `{"worker":{{worker}}}`, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	w := &Worker{ID: 7, Client: srv.Client(), Steps: []Step{{Request: req}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 1000)
+	w.Run(ctx, results)
+	srv.Close() // waits for in-flight handlers
+	close(got)
+
+	var n int
+	for s := range got {
+		n++
+		seq := strings.TrimPrefix(s.path, "/items/")
+		if s.header != "w7-"+seq {
+			t.Errorf("header = %q, want w7-%s", s.header, seq)
+		}
+		if s.body != `{"worker":7}` {
+			t.Errorf("body = %q, want {\"worker\":7}", s.body)
+		}
+	}
+	if n == 0 {
+		t.Fatal("server received no requests")
+	}
+}
+
+func TestRunFlowExtractsAndReportsTransactions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token": "secret"}`))
+	})
+	mux.HandleFunc("GET /items", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	scope := tmpl.NewScope()
+	login, err := NewRequest("POST", srv.URL+"/login", nil, "", scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractor, err := flow.NewExtractor(flow.ExtractDef{Var: "token", From: "json", Expr: "$.token"}, scope.Declare("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := NewRequest("GET", srv.URL+"/items", http.Header{"Authorization": {"Bearer {{.token}}"}}, "", scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &Worker{Client: srv.Client(), Steps: []Step{
+		{Name: "login", Request: login, Extract: []*flow.Extractor{extractor}},
+		{Name: "items", Request: items},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 10000)
+	w.Run(ctx, results)
+	close(results)
+
+	var steps [2]int
+	var transactions int
+	for r := range results {
+		if r.Error != nil {
+			continue // cancellation at shutdown
+		}
+		if r.StatusCode != http.StatusOK {
+			t.Fatalf("step %d got status %d; token not propagated", r.Step, r.StatusCode)
+		}
+		if r.Transaction {
+			transactions++
+			if r.Step != 1 {
+				t.Errorf("successful transaction ended at step %d, want 1", r.Step)
+			}
+			continue
+		}
+		steps[r.Step]++
+	}
+
+	if transactions == 0 {
+		t.Fatal("expected transaction results")
+	}
+	if steps[0] < transactions || steps[1] < transactions {
+		t.Errorf("steps %v fewer than %d transactions", steps, transactions)
+	}
+}
+
+func TestRunFlowStopsAtFailedStep(t *testing.T) {
+	var second atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/first", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK) // no token in the body
+	})
+	mux.HandleFunc("/second", func(w http.ResponseWriter, r *http.Request) {
+		second.Add(1)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	scope := tmpl.NewScope()
+	first, _ := NewRequest("GET", srv.URL+"/first", nil, "", scope)
+	extractor, _ := flow.NewExtractor(flow.ExtractDef{Var: "token", From: "header", Expr: "X-Token"}, scope.Declare("token"))
+	next, _ := NewRequest("GET", srv.URL+"/second", nil, "", scope)
+
+	w := &Worker{Client: srv.Client(), Steps: []Step{
+		{Request: first, Extract: []*flow.Extractor{extractor}},
+		{Request: next},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 10000)
+	w.Run(ctx, results)
+	close(results)
+
+	if n := second.Load(); n != 0 {
+		t.Errorf("second step ran %d times after extraction failed", n)
+	}
+	var failedTx int
+	for r := range results {
+		if r.Transaction && r.Step == 0 && r.Error != nil && strings.Contains(r.Error.Error(), "extract token") {
+			failedTx++
+		}
+	}
+	if failedTx == 0 {
+		t.Error("expected failed transactions attributed to the extraction error")
+	}
+}
+
+// sessionServer sets a session cookie on requests that arrive without one
+// and counts requests with and without it.
+func sessionServer(with, without *atomic.Int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("sid"); err == nil {
+			with.Add(1)
+			return
+		}
+		without.Add(1)
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s", Path: "/"})
+	}))
+}
+
+func TestRunCookieSessions(t *testing.T) {
+	tests := []struct {
+		name    string
+		reset   int
+		check   func(with, without int64) bool
+		explain string
+	}{
+		{name: "kept", reset: 0, check: func(with, without int64) bool { return without == 1 && with > 0 }, explain: "one anonymous request"},
+		{name: "reset every 3", reset: 3, check: func(with, without int64) bool { return without > 1 && with >= without }, explain: "a new session every 3 iterations"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var with, without atomic.Int64
+			srv := sessionServer(&with, &without)
+			defer srv.Close()
+
+			w := newWorker(t, srv.Client(), "GET", srv.URL)
+			w.Cookies = true
+			w.SessionReset = tt.reset
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			results := make(chan Result, 10000)
+			w.Run(ctx, results)
+
+			if !tt.check(with.Load(), without.Load()) {
+				t.Errorf("with cookie = %d, without = %d; want %s", with.Load(), without.Load(), tt.explain)
+			}
+		})
+	}
+}
+
+func TestRunWithoutCookiesStaysAnonymous(t *testing.T) {
+	var with, without atomic.Int64
+	srv := sessionServer(&with, &without)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 10000)
+	newWorker(t, srv.Client(), "GET", srv.URL).Run(ctx, results)
+
+	if with.Load() != 0 {
+		t.Errorf("%d requests carried a cookie without a jar", with.Load())
+	}
+}
+
+func TestRunThinkTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	w := newWorker(t, srv.Client(), "GET", srv.URL)
+	w.Think = dist.Constant(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 110*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 100)
+	w.Run(ctx, results)
+	close(results)
+
+	var n int
+	for r := range results {
+		n++
+		if r.Think != 20*time.Millisecond {
+			t.Errorf("Think = %s, want 20ms", r.Think)
+		}
+		if r.Duration >= 20*time.Millisecond {
+			t.Errorf("Duration %s includes think time", r.Duration)
+		}
+	}
+	// 110ms of 20ms pauses leaves room for about 5 requests.
+	if n < 3 || n > 7 {
+		t.Errorf("sent %d requests, want about 5 with 20ms think time", n)
+	}
+}
+
+func TestRunPacing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	w := newWorker(t, srv.Client(), "GET", srv.URL)
+	w.Pacing = 40 * time.Millisecond
+	w.Think = dist.Constant(time.Hour) // replaced by pacing after the last step
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 100)
+	w.Run(ctx, results)
+	close(results)
+
+	var n int
+	for r := range results {
+		n++
+		if r.Think != 0 {
+			t.Errorf("Think = %s, want 0 when pacing governs the gap", r.Think)
+		}
+		if r.Pacing <= 0 || r.Pacing > 40*time.Millisecond || r.Overran {
+			t.Errorf("Pacing = %s (overran %v), want a wait within the 40ms interval", r.Pacing, r.Overran)
+		}
+	}
+	// Iterations start at 0, 40, 80 and 120ms.
+	if n < 3 || n > 5 {
+		t.Errorf("ran %d iterations in 150ms, want about 4 at a 40ms pace", n)
+	}
+}
+
+func TestRunPacingOverrun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(15 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	w := newWorker(t, srv.Client(), "GET", srv.URL)
+	w.Pacing = 5 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 100)
+	w.Run(ctx, results)
+	close(results)
+
+	var overran int
+	for r := range results {
+		if r.Error == nil && r.Overran {
+			overran++
+		}
+	}
+	if overran == 0 {
+		t.Error("expected iterations slower than the pacing interval to be flagged")
+	}
+}
+
+func TestRunWaitsAtGate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	w := newWorker(t, srv.Client(), "GET", srv.URL)
+	w.Gate = NewGate(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan Result, 1000)
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx, results)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := len(results); n != 0 {
+		t.Fatalf("sent %d requests while held at the gate", n)
+	}
+
+	w.Gate.SetLimit(1)
+	select {
+	case <-results:
+	case <-time.After(2 * time.Second):
+		t.Fatal("worker did not start after the gate was raised")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("worker did not stop after context cancellation")
+	}
+}
+
+func TestRunRecordsDetail(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello, world")
+	}))
+	defer srv.Close()
+
+	w := newWorker(t, srv.Client(), "GET", srv.URL+"/path?q=1")
+	w.ID = 3
+	w.Detail = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 1000)
+	w.Run(ctx, results)
+	close(results)
+
+	var got []*Detail
+	for r := range results {
+		if r.Error == nil {
+			got = append(got, r.Detail)
+		}
+	}
+	if len(got) < 2 {
+		t.Fatalf("got %d successful requests, want at least 2", len(got))
+	}
+
+	first := got[0]
+	if first == nil {
+		t.Fatal("no detail recorded")
+	}
+	if first.Worker != 3 || first.Method != "GET" || first.URL != srv.URL+"/path?q=1" || first.Bytes != 12 {
+		t.Errorf("detail = %+v", first)
+	}
+	if first.Reused || first.Connect <= 0 || first.TLS <= 0 || first.TTFB <= 0 || first.Start.IsZero() {
+		t.Errorf("first request should open a new TLS connection: %+v", first)
+	}
+	if second := got[1]; !second.Reused || second.Connect != 0 || second.TLS != 0 {
+		t.Errorf("second request should reuse the connection: %+v", second)
+	}
+}
+
+func TestRunSendsTraceparent(t *testing.T) {
+	var mu sync.Mutex
+	sent := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent[r.Header.Get("traceparent")] = true
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	w := newWorker(t, srv.Client(), "GET", srv.URL)
+	w.Trace = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results := make(chan Result, 1000)
+	w.Run(ctx, results)
+	close(results)
+	// Close waits for handlers still running, so sent is complete.
+	srv.Close()
+
+	seen := make(map[string]bool)
+	for r := range results {
+		if r.Error != nil {
+			continue
+		}
+		if !r.Trace.IsValid() {
+			t.Fatal("result has no trace")
+		}
+		tp := r.Trace.Traceparent()
+		if !sent[tp] {
+			t.Errorf("result trace %s was not sent", tp)
+		}
+		if seen[r.Trace.Trace()] {
+			t.Errorf("trace ID %s reused", r.Trace.Trace())
+		}
+		seen[r.Trace.Trace()] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("got %d traced requests, want at least 2", len(seen))
+	}
+}